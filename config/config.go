@@ -1,13 +1,33 @@
 package config
 
+import "time"
+
 //Properties Configuration properties based on env variables.
 type Properties struct {
-	Port              string `env:"MY_APP_PORT" env-default:"8080"`
-	Host              string `env:"HOST" env-default:"localhost"`
-	DBHost            string `env:"DB_HOST" env-default:"localhost"`
-	DBPort            string `env:"DB_PORT" env-default:"27017"`
-	DBName            string `env:"DB_NAME" env-default:"tronics"`
-	ProductCollection string `env:"PRODUCTS_COL_NAME" env-default:"products"`
-	UsersCollection   string `env:"USERS_COL_NAME" env-default:"users"`
-	JwtTokenSecret    string `env:"JWT_TOKEN_SECRET" env-default:"abrakadabra"`
+	Port                string        `env:"MY_APP_PORT" env-default:"8080"`
+	Host                string        `env:"HOST" env-default:"localhost"`
+	DBHost              string        `env:"DB_HOST" env-default:"localhost"`
+	DBPort              string        `env:"DB_PORT" env-default:"27017"`
+	DBName              string        `env:"DB_NAME" env-default:"tronics"`
+	ProductCollection   string        `env:"PRODUCTS_COL_NAME" env-default:"products"`
+	UsersCollection     string        `env:"USERS_COL_NAME" env-default:"users"`
+	SuggestCollection   string        `env:"SUGGEST_COL_NAME" env-default:"product_suggestions"`
+	RevCollection       string        `env:"REVISIONS_COL_NAME" env-default:"product_revisions"`
+	RatesCollection     string        `env:"RATES_COL_NAME" env-default:"exchange_rates"`
+	PromoCollection     string        `env:"PROMOTIONS_COL_NAME" env-default:"promotions"`
+	StockCollection     string        `env:"INVENTORY_COL_NAME" env-default:"inventory"`
+	WarehouseCollection string        `env:"WAREHOUSES_COL_NAME" env-default:"warehouses"`
+	LocationCollection  string        `env:"WAREHOUSE_STOCK_COL_NAME" env-default:"warehouse_stock"`
+	VendorCollection    string        `env:"VENDORS_COL_NAME" env-default:"vendors"`
+	CategoryCollection  string        `env:"CATEGORIES_COL_NAME" env-default:"categories"`
+	ChangeCollection    string        `env:"CHANGES_COL_NAME" env-default:"catalog_changes"`
+	ImageBucket         string        `env:"IMAGES_BUCKET" env-default:"product_images"`
+	MaxImageSize        int64         `env:"MAX_IMAGE_SIZE" env-default:"5242880"`
+	RatesFile           string        `env:"RATES_FILE"`
+	BaseCurrency        string        `env:"BASE_CURRENCY" env-default:"USD"`
+	MigratePrices       bool          `env:"MIGRATE_PRICES" env-default:"false"`
+	MigrateVendors      bool          `env:"MIGRATE_VENDORS" env-default:"false"`
+	JwtTokenSecret      string        `env:"JWT_TOKEN_SECRET" env-default:"abrakadabra"`
+	TrashRetention      time.Duration `env:"TRASH_RETENTION" env-default:"720h"`
+	PurgeInterval       time.Duration `env:"PURGE_INTERVAL" env-default:"1h"`
 }
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//expandedProduct is a product along with the accessory products $lookup joined to it
+type expandedProduct struct {
+	Product     `bson:",inline"`
+	Accessories []Product `bson:"accessory_products"`
+}
+
+//existingProducts is the set of ids which are products not in the trash
+func existingProducts(ctx context.Context, ids []primitive.ObjectID, collection dbiface.CollectionAPI) (map[primitive.ObjectID]bool, *echo.HTTPError) {
+	existing := make(map[primitive.ObjectID]bool)
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "deleted_at": nil},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		log.Errorf("Unable to find the accessories : %v", err)
+		return existing, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the accessories"})
+	}
+	var products []Product
+	if err := cursor.All(ctx, &products); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return existing, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the accessories"})
+	}
+	for _, product := range products {
+		existing[product.ID] = true
+	}
+	return existing, nil
+}
+
+//unknownAccessory is the first accessory of product which is neither an existing product nor
+//product itself, if any
+func unknownAccessory(product Product, existing map[primitive.ObjectID]bool) (primitive.ObjectID, bool) {
+	for _, id := range product.AccessoryIDs {
+		if id == product.ID || !existing[id] {
+			return id, true
+		}
+	}
+	return primitive.NilObjectID, false
+}
+
+//checkAccessories fails with 400 Bad Request unless the accessories added to product are
+//existing products
+func checkAccessories(ctx context.Context, product, previous Product, collection dbiface.CollectionAPI) *echo.HTTPError {
+	added := addedIDs(previous.AccessoryIDs, product.AccessoryIDs)
+	if len(added) == 0 {
+		return nil
+	}
+	existing, httpError := existingProducts(ctx, added, collection)
+	if httpError != nil {
+		return httpError
+	}
+	for _, id := range previous.AccessoryIDs {
+		existing[id] = true
+	}
+	if id, ok := unknownAccessory(product, existing); ok {
+		return echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: fmt.Sprintf("unknown accessory %s", id.Hex())})
+	}
+	return nil
+}
+
+//expandAccessories reports whether ?expand= asks for the accessory products to be inlined
+func expandAccessories(c echo.Context) (bool, *echo.HTTPError) {
+	expand := false
+	for _, raw := range c.QueryParams()["expand"] {
+		for _, name := range strings.Split(raw, ",") {
+			if name != "accessories" {
+				return false, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "expand only supports accessories"})
+			}
+			expand = true
+		}
+	}
+	return expand, nil
+}
+
+//findExpandedProduct finds a product and joins the products of its accessories, in the order
+//of AccessoryIDs. Accessories which have since been deleted are left out.
+func findExpandedProduct(ctx context.Context, id string, collection dbiface.CollectionAPI) (Product, *echo.HTTPError) {
+	var product Product
+	docID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Errorf("Unable to convert to Object ID : %v", err)
+		return product,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to convert to ObjectID"})
+	}
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"_id": docID, "deleted_at": nil}},
+		bson.M{"$lookup": bson.M{
+			"from":         collection.Name(),
+			"localField":   "accessory_ids",
+			"foreignField": "_id",
+			"as":           "accessory_products",
+		}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Errorf("Unable to find the product : %v", err)
+		return product, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the product"})
+	}
+	var found []expandedProduct
+	if err := cursor.All(ctx, &found); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return product, echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse retrieved product"})
+	}
+	if len(found) == 0 {
+		return product, echo.NewHTTPError(http.StatusNotFound, errorMessage{Message: "unable to find the product"})
+	}
+	product = found[0].Product
+	byID := make(map[primitive.ObjectID]Product, len(found[0].Accessories))
+	for _, accessory := range found[0].Accessories {
+		if accessory.DeletedAt == nil {
+			byID[accessory.ID] = accessory
+		}
+	}
+	product.AccessoryProducts = []Product{}
+	for _, accessoryID := range product.AccessoryIDs {
+		if accessory, ok := byID[accessoryID]; ok {
+			product.AccessoryProducts = append(product.AccessoryProducts, accessory)
+		}
+	}
+	return product, nil
+}
+
+//GetCompatibleProducts gets a page of the products which the product is an accessory of, it
+//filters and pages like GetProducts
+func (h *ProductHandler) GetCompatibleProducts(c echo.Context) error {
+	product, httpError := findProduct(context.Background(), c.Param("id"), h.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	filter, httpError := productFilter(withoutKeys(c.QueryParams(), displayCurrencyParam, "accessory_ids"))
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	filter["accessory_ids"] = product.ID
+	return h.listProducts(c, filter)
+}
@@ -0,0 +1,500 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+	//MIMENDJSON is the media type of newline delimited JSON
+	MIMENDJSON = "application/x-ndjson"
+	//listSeparator separates the items of a list in a CSV cell
+	listSeparator = ";"
+)
+
+//catalogColumn is a column of the CSV catalog, named after the JSON field of the product
+type catalogColumn struct {
+	Name string
+	Kind columnKind
+}
+
+//columnKind is how the cells of a column are converted to JSON
+type columnKind int
+
+const (
+	kindString columnKind = iota
+	kindInt
+	kindBool
+	kindList
+	//kindJSON cells hold a JSON document, for the fields which are not flat
+	kindJSON
+)
+
+//catalogColumns are the columns of the CSV catalog, exported in this order
+var catalogColumns = []catalogColumn{
+	{"_id", kindString},
+	{"product_name", kindString},
+	{"price", kindString},
+	{"currency", kindString},
+	{"discount", kindInt},
+	{"vendor", kindString},
+	{"categories", kindList},
+	{"accessories", kindList},
+	{"accessory_ids", kindList},
+	{"is_essential", kindBool},
+	{"variants", kindJSON},
+	{"specs", kindJSON},
+}
+
+//upsertKeys are the fields an import can match existing products on
+var upsertKeys = map[string]bool{"_id": true, "product_name": true}
+
+//importLine is the outcome of importing a line of the catalog
+type importLine struct {
+	Line   int         `json:"line"`
+	Status int         `json:"status"`
+	ID     interface{} `json:"_id,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+//importReport is the outcome of importing a catalog
+type importReport struct {
+	DryRun   bool         `json:"dry_run"`
+	Created  int          `json:"created"`
+	Updated  int          `json:"updated"`
+	Rejected int          `json:"rejected"`
+	Lines    []importLine `json:"lines"`
+}
+
+func catalogFormat(c echo.Context) (string, *echo.HTTPError) {
+	switch format := c.QueryParam("format"); format {
+	case "", formatCSV:
+		return formatCSV, nil
+	case formatNDJSON:
+		return formatNDJSON, nil
+	}
+	return "", echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "format must be csv or ndjson"})
+}
+
+//csvRecord is the CSV row of product
+func csvRecord(product Product) ([]string, error) {
+	raw, err := json.Marshal(product)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	if product.ID.IsZero() {
+		delete(fields, "_id")
+	}
+	record := make([]string, len(catalogColumns))
+	for i, col := range catalogColumns {
+		value := fields[col.Name]
+		switch {
+		case value == nil:
+		case col.Kind == kindJSON:
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			record[i] = string(raw)
+		case col.Kind == kindList:
+			items, _ := value.([]interface{})
+			cells := make([]string, len(items))
+			for j, item := range items {
+				cells[j] = fmt.Sprint(item)
+			}
+			record[i] = strings.Join(cells, listSeparator)
+		default:
+			record[i] = fmt.Sprint(value)
+		}
+	}
+	return record, nil
+}
+
+//decodeCSVRecord decodes a CSV row into a product, empty cells are left out
+func decodeCSVRecord(header []catalogColumn, record []string) (Product, error) {
+	var product Product
+	if len(record) != len(header) {
+		return product, fmt.Errorf("expected %d columns, got %d", len(header), len(record))
+	}
+	fields := make(map[string]interface{}, len(header))
+	for i, col := range header {
+		cell := strings.TrimSpace(record[i])
+		if cell == "" {
+			continue
+		}
+		switch col.Kind {
+		case kindInt:
+			n, err := strconv.ParseInt(cell, 10, 64)
+			if err != nil {
+				return product, fmt.Errorf("invalid %s %q", col.Name, cell)
+			}
+			fields[col.Name] = n
+		case kindBool:
+			b, err := strconv.ParseBool(cell)
+			if err != nil {
+				return product, fmt.Errorf("invalid %s %q", col.Name, cell)
+			}
+			fields[col.Name] = b
+		case kindList:
+			items := strings.Split(cell, listSeparator)
+			for j := range items {
+				items[j] = strings.TrimSpace(items[j])
+			}
+			fields[col.Name] = items
+		case kindJSON:
+			var doc interface{}
+			if err := json.Unmarshal([]byte(cell), &doc); err != nil {
+				return product, fmt.Errorf("invalid %s %q", col.Name, cell)
+			}
+			fields[col.Name] = doc
+		default:
+			fields[col.Name] = cell
+		}
+	}
+	raw, err := json.Marshal(fields)
+	if err == nil {
+		err = json.Unmarshal(raw, &product)
+	}
+	return product, err
+}
+
+//csvHeader maps the header of a CSV catalog to its columns, unknown columns are rejected
+func csvHeader(record []string) ([]catalogColumn, error) {
+	known := make(map[string]catalogColumn, len(catalogColumns))
+	for _, col := range catalogColumns {
+		known[col.Name] = col
+	}
+	header := make([]catalogColumn, len(record))
+	seen := make(map[string]bool, len(record))
+	for i, name := range record {
+		name = strings.TrimSpace(name)
+		col, ok := known[name]
+		if !ok || seen[name] {
+			return nil, fmt.Errorf("unknown or repeated column %q", name)
+		}
+		seen[name] = true
+		header[i] = col
+	}
+	return header, nil
+}
+
+//readCatalog decodes the products of a catalog, results holds the line of every product and
+//the failure of the ones which cannot be decoded
+func readCatalog(format string, body io.Reader) ([]Product, []itemResult, *echo.HTTPError) {
+	var products []Product
+	var results []itemResult
+	if format == formatNDJSON {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			raw := bytes.TrimSpace(scanner.Bytes())
+			if len(raw) == 0 {
+				continue
+			}
+			var product Product
+			result := itemResult{Index: line}
+			dec := json.NewDecoder(bytes.NewReader(raw))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&product); err != nil {
+				result.Status, result.Error = http.StatusBadRequest, err.Error()
+			}
+			products = append(products, product)
+			results = append(results, result)
+		}
+		if err := scanner.Err(); err != nil {
+			log.Errorf("Unable to read the catalog : %v", err)
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "unable to read the catalog"})
+		}
+		return products, results, nil
+	}
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	record, err := reader.Read()
+	if err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "the catalog has no header"})
+	}
+	header, err := csvHeader(record)
+	if err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: err.Error()})
+	}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Errorf("Unable to read the catalog : %v", err)
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: fmt.Sprintf("line %d: %v", line, err)})
+		}
+		product, err := decodeCSVRecord(header, record)
+		result := itemResult{Index: line}
+		if err != nil {
+			result.Status, result.Error = http.StatusBadRequest, err.Error()
+		}
+		products = append(products, product)
+		results = append(results, result)
+	}
+	return products, results, nil
+}
+
+//ExportProducts streams the products matching the filters of the query string as CSV or
+//NDJSON, one product at a time straight from the cursor. Prices are exported as stored, the
+//display currency of the other product reads is left out of the filters.
+func (h *ProductHandler) ExportProducts(c echo.Context) error {
+	format, httpError := catalogFormat(c)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	filter, httpError := productFilter(withoutKeys(c.QueryParams(), "format", displayCurrencyParam))
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	ctx := context.Background()
+	cursor, err := h.Col.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		log.Errorf("Unable to find the products : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to find the products"})
+	}
+	defer cursor.Close(ctx)
+
+	res := c.Response()
+	contentType := "text/csv; charset=utf-8"
+	if format == formatNDJSON {
+		contentType = MIMENDJSON
+	}
+	res.Header().Set(echo.HeaderContentType, contentType)
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="products.%s"`, format))
+	res.WriteHeader(http.StatusOK)
+	csvWriter := csv.NewWriter(res)
+	jsonEncoder := json.NewEncoder(res)
+	if format == formatCSV {
+		header := make([]string, len(catalogColumns))
+		for i, col := range catalogColumns {
+			header[i] = col.Name
+		}
+		csvWriter.Write(header)
+	}
+	for cursor.Next(ctx) {
+		var product Product
+		if err = cursor.Decode(&product); err != nil {
+			break
+		}
+		if format == formatNDJSON {
+			err = jsonEncoder.Encode(product)
+		} else {
+			var record []string
+			if record, err = csvRecord(product); err == nil {
+				err = csvWriter.Write(record)
+			}
+			csvWriter.Flush()
+		}
+		if err != nil {
+			break
+		}
+		res.Flush()
+	}
+	if err == nil {
+		err = cursor.Err()
+	}
+	if err != nil {
+		//the status is already sent, the truncated body is all the client gets
+		log.Errorf("Unable to export the products : %v", err)
+	}
+	return nil
+}
+
+//importTargets finds, in upsert mode, the existing product every valid line refers to by key.
+//Lines repeating the key of an earlier line are rejected.
+func importTargets(ctx context.Context, key string, products []Product, results []itemResult, collection dbiface.CollectionAPI) (map[int]Product, *echo.HTTPError) {
+	targets := make(map[int]Product)
+	seen := make(map[string]int)
+	for i, product := range products {
+		if results[i].Status != 0 {
+			continue
+		}
+		filter := bson.M{"deleted_at": nil}
+		value := product.Name
+		if key == "_id" {
+			if product.ID.IsZero() {
+				results[i].Status, results[i].Error = http.StatusBadRequest, "_id is required to upsert by _id"
+				continue
+			}
+			value = product.ID.Hex()
+			filter["_id"] = product.ID
+		} else {
+			filter["product_name"] = product.Name
+		}
+		if line, ok := seen[value]; ok {
+			results[i].Status, results[i].Error = http.StatusConflict, fmt.Sprintf("%s repeats line %d", key, line)
+			continue
+		}
+		seen[value] = results[i].Index
+		var existing []Product
+		cursor, err := collection.Find(ctx, filter, options.Find().SetLimit(2))
+		if err == nil {
+			err = cursor.All(ctx, &existing)
+		}
+		switch {
+		case err != nil:
+			log.Errorf("Unable to find the product : %v", err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the products"})
+		case len(existing) == 0 && key == "_id":
+			results[i].Status, results[i].Error = http.StatusNotFound, "unable to find the product"
+		case len(existing) == 0:
+		case len(existing) > 1:
+			results[i].Status, results[i].Error = http.StatusConflict, fmt.Sprintf("%s matches several products", key)
+		default:
+			targets[i] = existing[0]
+		}
+	}
+	return targets, nil
+}
+
+//updateImported replaces previous with the imported product, unless someone else changed it
+func updateImported(ctx context.Context, product, previous Product, collection dbiface.CollectionAPI) (Product, itemResult) {
+	product.ID = previous.ID
+	product.Version = previous.Version + 1
+	product.CreatedAt = previous.CreatedAt
+	product.UpdatedAt = now()
+	product.Images = previous.Images
+	product.DeletedAt, product.DeletedBy = nil, ""
+	update, err := changedFields(previous, product)
+	var res *mongo.UpdateResult
+	if err == nil {
+		res, err = collection.UpdateOne(ctx, versionFilter(previous.ID, previous.Version), update)
+	}
+	if isDuplicateKey(err) {
+		return product, itemResult{Status: http.StatusConflict, Error: "a variant SKU is already taken"}
+	}
+	if err != nil {
+		log.Errorf("Unable to update the product : %v", err)
+		return product, itemResult{Status: http.StatusInternalServerError, Error: "unable to update the product"}
+	}
+	if res.MatchedCount == 0 {
+		return product, itemResult{Status: http.StatusConflict, Error: "the product changed during the import"}
+	}
+	return product, itemResult{Status: http.StatusOK, ID: product.ID}
+}
+
+//ImportProducts imports a CSV or NDJSON catalog. Every line is validated like CreateProducts
+//and the answer reports the outcome of every line by line number. Like CreateProducts its
+//status is the one shared by every line, or 207 Multi-Status when they differ.
+//upsert=_id|product_name updates the products matching the key instead of creating them,
+//dry_run=true only reports what the import would do.
+func (h *ProductHandler) ImportProducts(c echo.Context) error {
+	ctx := context.Background()
+	format, httpError := catalogFormat(c)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	dryRun := false
+	if raw := c.QueryParam("dry_run"); raw != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(raw); err != nil {
+			return c.JSON(http.StatusBadRequest, errorMessage{Message: "dry_run must be true or false"})
+		}
+	}
+	key := c.QueryParam("upsert")
+	if key != "" && !upsertKeys[key] {
+		return c.JSON(http.StatusBadRequest, errorMessage{Message: "upsert must be _id or product_name"})
+	}
+	products, results, httpError := readCatalog(format, c.Request().Body)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	for i, product := range products {
+		if results[i].Status != 0 {
+			continue
+		}
+		if err := v.Struct(product); err != nil {
+			results[i].Status, results[i].Error = http.StatusBadRequest, err.Error()
+		}
+	}
+	if httpError := checkBatchReferences(ctx, products, results, h.Col, h.VendorCol, h.CategoryCol); httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	targets := make(map[int]Product)
+	if key != "" {
+		if targets, httpError = importTargets(ctx, key, products, results, h.Col); httpError != nil {
+			return c.JSON(httpError.Code, httpError.Message)
+		}
+	}
+
+	var created []Product
+	for i := range products {
+		if results[i].Status != 0 {
+			continue
+		}
+		previous, update := targets[i]
+		switch {
+		case dryRun && update:
+			results[i].Status, results[i].ID = http.StatusOK, previous.ID
+		case dryRun:
+			results[i].Status = http.StatusCreated
+		case update:
+			line := results[i].Index
+			products[i], results[i] = updateImported(ctx, products[i], previous, h.Col)
+			results[i].Index = line
+			if results[i].Status == http.StatusOK {
+				if httpError := recordRevision(c, revisionUpdate, &previous, products[i], h.RevCol); httpError != nil {
+					results[i].Status, results[i].Error = httpError.Code, "unable to record the revision"
+				}
+				refreshSuggestions(ctx, previous, products[i], h.SuggestCol)
+			}
+		}
+	}
+	if !dryRun {
+		lines := make([]int, len(results))
+		for i := range results {
+			lines[i] = results[i].Index
+		}
+		results = insertProducts(ctx, products, results, false, h.Col)
+		for i := range results {
+			results[i].Index = lines[i]
+			if results[i].Status == http.StatusCreated {
+				created = append(created, products[i])
+				if httpError := recordRevision(c, revisionCreate, nil, products[i], h.RevCol); httpError != nil {
+					results[i].Status, results[i].Error = httpError.Code, "unable to record the revision"
+				}
+			}
+		}
+		if err := recordSuggestions(ctx, created, 1, h.SuggestCol); err != nil {
+			log.Errorf("Unable to update the suggestions : %v", err)
+		}
+	}
+
+	report := importReport{DryRun: dryRun, Lines: make([]importLine, len(results))}
+	for i, result := range results {
+		report.Lines[i] = importLine{Line: result.Index, Status: result.Status, ID: result.ID, Error: result.Error}
+		switch result.Status {
+		case http.StatusCreated:
+			report.Created++
+		case http.StatusOK:
+			report.Updated++
+		default:
+			report.Rejected++
+		}
+	}
+	return c.JSON(batchStatus(results), report)
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func importCatalog(t *testing.T, query, body string) (int, importReport) {
+	var report importReport
+	req := httptest.NewRequest(http.MethodPost, "/products/import"+query, strings.NewReader(body))
+	res := httptest.NewRecorder()
+	e := echo.New()
+	c := e.NewContext(req, res)
+	h.Col = col
+	err := h.ImportProducts(c)
+	assert.Nil(t, err)
+	err = json.Unmarshal(res.Body.Bytes(), &report)
+	assert.Nil(t, err)
+	return res.Code, report
+}
+
+func TestCatalog(t *testing.T) {
+	t.Run("csv records", func(t *testing.T) {
+		header, err := csvHeader([]string{"product_name", "price", "currency", "vendor", "discount", "accessories", "is_essential"})
+		assert.Nil(t, err)
+		product, err := decodeCSVRecord(header, []string{"walkman", "99.90", "USD", "sony", "5", "earbuds; case", "true"})
+		assert.Nil(t, err)
+		assert.Equal(t, "99.90", product.Price.String())
+		assert.Equal(t, []string{"earbuds", "case"}, product.Accessories)
+		assert.True(t, product.IsEssential)
+
+		record, err := csvRecord(product)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"", "walkman", "99.90", "USD", "5", "sony", "", "earbuds;case", "", "true", "", ""}, record)
+
+		variant := `[{"sku":"WM-1","options":{"colour":"black"},"stock":3}]`
+		header, err = csvHeader([]string{"product_name", "price", "currency", "vendor", "variants"})
+		assert.Nil(t, err)
+		product, err = decodeCSVRecord(header, []string{"walkman", "99.90", "USD", "sony", variant})
+		assert.Nil(t, err)
+		assert.Equal(t, "WM-1", product.Variants[0].SKU)
+		record, err = csvRecord(product)
+		assert.Nil(t, err)
+		assert.JSONEq(t, variant, record[10])
+
+		_, err = csvHeader([]string{"product_name", "$where"})
+		assert.NotNil(t, err)
+		_, err = decodeCSVRecord(header, []string{"walkman", "99.90", "USD", "sony", "five", "", ""})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("import a csv catalog", func(t *testing.T) {
+		body := "product_name,price,currency,vendor,discount\n" +
+			"walkman,99.90,USD,sony,5\n" +
+			"discman,free,USD,sony,0\n" +
+			"ipod,199,USD,nokia,0\n"
+		code, report := importCatalog(t, "", body)
+		assert.Equal(t, http.StatusMultiStatus, code)
+		assert.Equal(t, 1, report.Created)
+		assert.Equal(t, 2, report.Rejected)
+		assert.Equal(t, []int{2, 3, 4}, []int{report.Lines[0].Line, report.Lines[1].Line, report.Lines[2].Line})
+		assert.Equal(t, http.StatusCreated, report.Lines[0].Status)
+		assert.Equal(t, http.StatusBadRequest, report.Lines[1].Status)
+		assert.Equal(t, "unknown vendor nokia", report.Lines[2].Error)
+	})
+
+	t.Run("dry run an ndjson upsert", func(t *testing.T) {
+		body := `{"product_name":"walkman","price":"89.90","currency":"USD","vendor":"sony"}` + "\n\n" +
+			`{"product_name":"minidisc","price":"149","currency":"USD","vendor":"sony"}` + "\n"
+		code, report := importCatalog(t, "?format=ndjson&upsert=product_name&dry_run=true", body)
+		assert.Equal(t, http.StatusMultiStatus, code)
+		assert.True(t, report.DryRun)
+		assert.Equal(t, 1, report.Updated)
+		assert.Equal(t, 1, report.Created)
+		assert.Equal(t, 3, report.Lines[1].Line)
+
+		count, err := col.CountDocuments(context.Background(), bson.M{"product_name": "minidisc"})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("upsert a csv catalog", func(t *testing.T) {
+		body := "product_name,price,currency,vendor\nwalkman,89.90,USD,sony\nwalkman,79.90,USD,sony\n"
+		code, report := importCatalog(t, "?upsert=product_name", body)
+		assert.Equal(t, http.StatusMultiStatus, code)
+		assert.Equal(t, http.StatusOK, report.Lines[0].Status)
+		assert.Equal(t, http.StatusConflict, report.Lines[1].Status)
+
+		var product Product
+		err := col.FindOne(context.Background(), bson.M{"product_name": "walkman"}).Decode(&product)
+		assert.Nil(t, err)
+		assert.Equal(t, "89.90", product.Price.String())
+		assert.Equal(t, int64(2), product.Version)
+	})
+
+	t.Run("upsert an ambiguous product name unhappy", func(t *testing.T) {
+		price, _ := ParseMoney("59.90")
+		res, err := col.InsertOne(context.Background(), Product{Name: "walkman", Price: price, Currency: "USD", Vendor: "sony"})
+		assert.Nil(t, err)
+		code, report := importCatalog(t, "?upsert=product_name", "product_name,price,currency,vendor\nwalkman,69.90,USD,sony\n")
+		assert.Equal(t, http.StatusConflict, code)
+		assert.Equal(t, http.StatusConflict, report.Lines[0].Status)
+		_, err = col.DeleteOne(context.Background(), bson.M{"_id": res.InsertedID})
+		assert.Nil(t, err)
+	})
+
+	t.Run("export the catalog", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/products/export?format=csv&product_name=walkman&currency=EUR", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.ExportProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		records, err := csv.NewReader(res.Body).ReadAll()
+		assert.Nil(t, err)
+		assert.Len(t, records, 2)
+		assert.Equal(t, "product_name", records[0][1])
+		assert.Equal(t, "89.90", records[1][2])
+
+		req = httptest.NewRequest(http.MethodGet, "/products/export?format=ndjson&product_name=walkman", nil)
+		res = httptest.NewRecorder()
+		c = e.NewContext(req, res)
+		err = h.ExportProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, MIMENDJSON, res.Header().Get(echo.HeaderContentType))
+		var product Product
+		err = json.NewDecoder(res.Body).Decode(&product)
+		assert.Nil(t, err)
+		assert.Equal(t, "walkman", product.Name)
+
+		_, err = col.DeleteMany(context.Background(), bson.M{"vendor": "sony"})
+		assert.Nil(t, err)
+	})
+}
@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//Category is a node of the product taxonomy. Ancestors is the path from the root down to the
+//parent, it lets a single query find every descendant of a category.
+//Attributes are the specs its products have, on top of the ones of its ancestors.
+type Category struct {
+	ID         primitive.ObjectID   `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name       string               `json:"name" bson:"name" validate:"required,max=50"`
+	Slug       string               `json:"slug" bson:"slug" validate:"required,max=50,slug"`
+	ParentID   *primitive.ObjectID  `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	Ancestors  []primitive.ObjectID `json:"ancestors" bson:"ancestors"`
+	Attributes []Attribute          `json:"attributes,omitempty" bson:"attributes,omitempty" validate:"max=50,dive"`
+	CreatedAt  time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+//CategoriesHandler a categories handler
+type CategoriesHandler struct {
+	Col     dbiface.CollectionAPI
+	ProdCol dbiface.CollectionAPI
+}
+
+//findCategory finds a category by ID or by slug
+func findCategory(ctx context.Context, idOrSlug string, collection dbiface.CollectionAPI) (Category, *echo.HTTPError) {
+	var category Category
+	filter := bson.M{"slug": idOrSlug}
+	if docID, err := primitive.ObjectIDFromHex(idOrSlug); err == nil {
+		filter = bson.M{"_id": docID}
+	}
+	if err := collection.FindOne(ctx, filter).Decode(&category); err != nil {
+		log.Errorf("Unable to find the category : %v", err)
+		return category, echo.NewHTTPError(http.StatusNotFound, errorMessage{Message: "unable to find the category"})
+	}
+	return category, nil
+}
+
+//findCategoryIDs is the set of the ids which match filter
+func findCategoryIDs(ctx context.Context, filter bson.M, collection dbiface.CollectionAPI) (map[primitive.ObjectID]bool, *echo.HTTPError) {
+	ids := make(map[primitive.ObjectID]bool)
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		log.Errorf("Unable to find the categories : %v", err)
+		return ids, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the categories"})
+	}
+	var categories []Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return ids, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the categories"})
+	}
+	for _, category := range categories {
+		ids[category.ID] = true
+	}
+	return ids, nil
+}
+
+//existingCategories is the set of ids which are categories
+func existingCategories(ctx context.Context, ids []primitive.ObjectID, collection dbiface.CollectionAPI) (map[primitive.ObjectID]bool, *echo.HTTPError) {
+	return findCategoryIDs(ctx, bson.M{"_id": bson.M{"$in": ids}}, collection)
+}
+
+//checkCategories fails with 400 Bad Request unless every id is a category
+func checkCategories(ctx context.Context, ids []primitive.ObjectID, collection dbiface.CollectionAPI) *echo.HTTPError {
+	if len(ids) == 0 {
+		return nil
+	}
+	existing, httpError := existingCategories(ctx, ids, collection)
+	if httpError != nil {
+		return httpError
+	}
+	for _, id := range ids {
+		if !existing[id] {
+			return echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: fmt.Sprintf("unknown category %s", id.Hex())})
+		}
+	}
+	return nil
+}
+
+//addedIDs are the ids of after which are not in before
+func addedIDs(before, after []primitive.ObjectID) []primitive.ObjectID {
+	had := make(map[primitive.ObjectID]bool, len(before))
+	for _, id := range before {
+		had[id] = true
+	}
+	var added []primitive.ObjectID
+	for _, id := range after {
+		if !had[id] {
+			added = append(added, id)
+		}
+	}
+	return added
+}
+
+//categoryPath is the ancestors of the children of parent
+func categoryPath(parent Category) []primitive.ObjectID {
+	path := make([]primitive.ObjectID, 0, len(parent.Ancestors)+1)
+	return append(append(path, parent.Ancestors...), parent.ID)
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+//decodeCategory decodes a category and resolves the ancestors of its parent
+func decodeCategory(ctx context.Context, c echo.Context, collection dbiface.CollectionAPI) (Category, *echo.HTTPError) {
+	var category Category
+	if err := json.NewDecoder(c.Request().Body).Decode(&category); err != nil {
+		log.Errorf("Unable to decode the category : %v", err)
+		return category, echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse request payload"})
+	}
+	if category.Slug == "" {
+		category.Slug = slugify(category.Name)
+	}
+	if err := v.Struct(category); err != nil {
+		log.Errorf("Unable to validate the category %+v %v", category, err)
+		return category, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: err.Error()})
+	}
+	category.Ancestors = []primitive.ObjectID{}
+	if category.ParentID != nil {
+		parent, httpError := findCategory(ctx, category.ParentID.Hex(), collection)
+		if httpError != nil {
+			return category, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "unknown parent category"})
+		}
+		category.Ancestors = categoryPath(parent)
+	}
+	return category, nil
+}
+
+//categoryInUse reports whether the category has children or products, including the ones
+//in the trash
+func categoryInUse(ctx context.Context, id primitive.ObjectID, collection, prodCol dbiface.CollectionAPI) (bool, *echo.HTTPError) {
+	children, err := collection.CountDocuments(ctx, bson.M{"parent_id": id}, options.Count().SetLimit(1))
+	if err == nil && children > 0 {
+		return true, nil
+	}
+	var products int64
+	if err == nil {
+		products, err = prodCol.CountDocuments(ctx, bson.M{"categories": id}, options.Count().SetLimit(1))
+	}
+	if err != nil {
+		log.Errorf("Unable to count the references to the category : %v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to count the references to the category"})
+	}
+	return products > 0, nil
+}
+
+//GetCategories gets the categories, ?parent=<id> only gets the children of a category
+//and ?parent=root the top level ones
+func (ch *CategoriesHandler) GetCategories(c echo.Context) error {
+	categories := []Category{}
+	filter := bson.M{}
+	switch parent := c.QueryParam("parent"); parent {
+	case "":
+	case "root":
+		filter["parent_id"] = nil
+	default:
+		parentID, err := primitive.ObjectIDFromHex(parent)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, errorMessage{Message: "parent must be an id or root"})
+		}
+		filter["parent_id"] = parentID
+	}
+	ctx := context.Background()
+	cursor, err := ch.Col.Find(ctx, filter, options.Find().SetSort(bson.M{"slug": 1}))
+	if err == nil {
+		err = cursor.All(ctx, &categories)
+	}
+	if err != nil {
+		log.Errorf("Unable to find the categories : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to find the categories"})
+	}
+	return c.JSON(http.StatusOK, categories)
+}
+
+//GetCategory gets a single category by ID or by slug
+func (ch *CategoriesHandler) GetCategory(c echo.Context) error {
+	category, httpError := findCategory(context.Background(), c.Param("id"), ch.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	return c.JSON(http.StatusOK, category)
+}
+
+//CreateCategory creates a category under parent_id, or at the top level without one
+func (ch *CategoriesHandler) CreateCategory(c echo.Context) error {
+	ctx := context.Background()
+	category, httpError := decodeCategory(ctx, c, ch.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	category.ID = primitive.NewObjectID()
+	category.CreatedAt = now()
+	category.UpdatedAt = category.CreatedAt
+	if _, err := ch.Col.InsertOne(ctx, category); err != nil {
+		if isDuplicateKey(err) {
+			return c.JSON(http.StatusConflict, errorMessage{Message: fmt.Sprintf("category %s already exists", category.Slug)})
+		}
+		log.Errorf("Unable to insert the category : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to insert the category"})
+	}
+	return c.JSON(http.StatusCreated, category)
+}
+
+//UpdateCategory replaces a category. Changing its parent moves the whole subtree, a category
+//cannot move under itself or one of its descendants.
+func (ch *CategoriesHandler) UpdateCategory(c echo.Context) error {
+	ctx := context.Background()
+	previous, httpError := findCategory(ctx, c.Param("id"), ch.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	category, httpError := decodeCategory(ctx, c, ch.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if containsID(category.Ancestors, previous.ID) {
+		return c.JSON(http.StatusBadRequest, errorMessage{Message: "a category cannot move under itself"})
+	}
+	category.ID = previous.ID
+	category.CreatedAt = previous.CreatedAt
+	category.UpdatedAt = now()
+	update, err := changedFields(previous, category)
+	if err == nil {
+		_, err = ch.Col.UpdateOne(ctx, bson.M{"_id": category.ID}, update)
+	}
+	if isDuplicateKey(err) {
+		return c.JSON(http.StatusConflict, errorMessage{Message: fmt.Sprintf("category %s already exists", category.Slug)})
+	}
+	if err != nil {
+		log.Errorf("Unable to update the category : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to update the category"})
+	}
+	if !equalIDs(previous.Ancestors, category.Ancestors) {
+		if err := moveSubtree(ctx, category, ch.Col); err != nil {
+			log.Errorf("Unable to move the descendants of the category : %v", err)
+			return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to move the descendants of the category"})
+		}
+	}
+	return c.JSON(http.StatusOK, category)
+}
+
+func equalIDs(a, b []primitive.ObjectID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//moveSubtree replaces, in the ancestors of every descendant of category, the part of the path
+//above category with the current ancestors of category
+func moveSubtree(ctx context.Context, category Category, collection dbiface.CollectionAPI) error {
+	ancestors := "$ancestors"
+	below := bson.M{"$slice": bson.A{
+		ancestors,
+		bson.M{"$add": bson.A{bson.M{"$indexOfArray": bson.A{ancestors, category.ID}}, 1}},
+		bson.M{"$size": ancestors},
+	}}
+	pipeline := bson.A{bson.M{"$set": bson.M{
+		"ancestors":  bson.M{"$concatArrays": bson.A{categoryPath(category), below}},
+		"updated_at": category.UpdatedAt,
+	}}}
+	_, err := collection.UpdateMany(ctx, bson.M{"ancestors": category.ID}, pipeline)
+	return err
+}
+
+//DeleteCategory deletes a category which has neither children nor products
+func (ch *CategoriesHandler) DeleteCategory(c echo.Context) error {
+	ctx := context.Background()
+	category, httpError := findCategory(ctx, c.Param("id"), ch.Col)
+	if httpError != nil {
+		if httpError.Code == http.StatusNotFound {
+			return c.JSON(http.StatusOK, 0)
+		}
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	inUse, httpError := categoryInUse(ctx, category.ID, ch.Col, ch.ProdCol)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if inUse {
+		return c.JSON(http.StatusConflict, errorMessage{Message: "category has children or products"})
+	}
+	res, err := ch.Col.DeleteOne(ctx, bson.M{"_id": category.ID})
+	if err != nil {
+		log.Errorf("Unable to delete the category : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to delete the category"})
+	}
+	return c.JSON(http.StatusOK, res.DeletedCount)
+}
+
+//GetCategoryProducts gets a page of the products of a category, it filters and pages like
+//GetProducts. include_descendants=true also gets the products of every subcategory.
+func (h *ProductHandler) GetCategoryProducts(c echo.Context) error {
+	ctx := context.Background()
+	category, httpError := findCategory(ctx, c.Param("id"), h.CategoryCol)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	descendants := false
+	if raw := c.QueryParam("include_descendants"); raw != "" {
+		var err error
+		if descendants, err = strconv.ParseBool(raw); err != nil {
+			return c.JSON(http.StatusBadRequest, errorMessage{Message: "include_descendants must be true or false"})
+		}
+	}
+	ids := bson.A{category.ID}
+	if descendants {
+		subtree, httpError := findCategoryIDs(ctx, bson.M{"ancestors": category.ID}, h.CategoryCol)
+		if httpError != nil {
+			return c.JSON(httpError.Code, httpError.Message)
+		}
+		for id := range subtree {
+			ids = append(ids, id)
+		}
+	}
+	filter, httpError := productFilter(withoutKeys(c.QueryParams(), displayCurrencyParam, "include_descendants", "categories"))
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	filter["categories"] = bson.M{"$in": ids}
+	return h.listProducts(c, filter)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func createCategory(t *testing.T, body string) Category {
+	var category Category
+	req := httptest.NewRequest(http.MethodPost, "/categories", strings.NewReader(body))
+	res := httptest.NewRecorder()
+	e := echo.New()
+	c := e.NewContext(req, res)
+	err := ch.CreateCategory(c)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, res.Code, body)
+	err = json.Unmarshal(res.Body.Bytes(), &category)
+	assert.Nil(t, err)
+	return category
+}
+
+func TestCategories(t *testing.T) {
+	var electronics, phones, smartphones, mobile Category
+	productID := primitive.NewObjectID()
+
+	t.Run("create categories", func(t *testing.T) {
+		electronics = createCategory(t, `{"name":"Electronics"}`)
+		phones = createCategory(t, fmt.Sprintf(`{"name":"Phones","parent_id":"%s"}`, electronics.ID.Hex()))
+		smartphones = createCategory(t, fmt.Sprintf(`{"name":"Smart phones","parent_id":"%s"}`, phones.ID.Hex()))
+		mobile = createCategory(t, `{"name":"Mobile"}`)
+		assert.Equal(t, "smart-phones", smartphones.Slug)
+		assert.Equal(t, []primitive.ObjectID{electronics.ID, phones.ID}, smartphones.Ancestors)
+
+		price, _ := ParseMoney("799")
+		_, err := col.InsertOne(context.Background(), Product{ID: productID, Name: "pixel", Price: price, Currency: "USD",
+			Vendor: "google", Categories: []primitive.ObjectID{smartphones.ID}})
+		assert.Nil(t, err)
+	})
+
+	t.Run("create a category under an unknown parent", func(t *testing.T) {
+		body := fmt.Sprintf(`{"name":"Tablets","parent_id":"%s"}`, primitive.NewObjectID().Hex())
+		req := httptest.NewRequest(http.MethodPost, "/categories", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		err := ch.CreateCategory(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("create a product in an unknown category", func(t *testing.T) {
+		var results []itemResult
+		body := fmt.Sprintf(`[{"product_name":"nexus","price":"99","currency":"USD","vendor":"google","categories":["%s"]}]`,
+			primitive.NewObjectID().Hex())
+		req := httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.CreateProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &results)
+		assert.Nil(t, err)
+		assert.Contains(t, results[0].Error, "unknown category")
+	})
+
+	t.Run("get the products of a category", func(t *testing.T) {
+		for query, count := range map[string]int{"": 0, "?include_descendants=true": 1} {
+			var products []Product
+			req := httptest.NewRequest(http.MethodGet, "/categories/electronics/products"+query, nil)
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			c.SetParamNames("id")
+			c.SetParamValues("electronics")
+			h.Col = col
+			err := h.GetCategoryProducts(c)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, res.Code)
+			err = json.Unmarshal(res.Body.Bytes(), &products)
+			assert.Nil(t, err)
+			assert.Len(t, products, count, query)
+		}
+	})
+
+	t.Run("move a subtree", func(t *testing.T) {
+		body := fmt.Sprintf(`{"name":"Phones","parent_id":"%s"}`, mobile.ID.Hex())
+		req := httptest.NewRequest(http.MethodPut, "/categories/phones", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(phones.ID.Hex())
+		err := ch.UpdateCategory(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		var moved Category
+		err = catCol.FindOne(context.Background(), bson.M{"_id": smartphones.ID}).Decode(&moved)
+		assert.Nil(t, err)
+		assert.Equal(t, []primitive.ObjectID{mobile.ID, phones.ID}, moved.Ancestors)
+	})
+
+	t.Run("move a category under itself", func(t *testing.T) {
+		body := fmt.Sprintf(`{"name":"Mobile","parent_id":"%s"}`, smartphones.ID.Hex())
+		req := httptest.NewRequest(http.MethodPut, "/categories/mobile", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(mobile.ID.Hex())
+		err := ch.UpdateCategory(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("delete categories", func(t *testing.T) {
+		for _, tc := range []struct {
+			id   primitive.ObjectID
+			code int
+		}{
+			{phones.ID, http.StatusConflict},
+			{smartphones.ID, http.StatusConflict},
+			{electronics.ID, http.StatusOK},
+		} {
+			req := httptest.NewRequest(http.MethodDelete, "/categories/"+tc.id.Hex(), nil)
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			c.SetParamNames("id")
+			c.SetParamValues(tc.id.Hex())
+			err := ch.DeleteCategory(c)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.code, res.Code, tc.id.Hex())
+		}
+		_, err := col.DeleteOne(context.Background(), bson.M{"_id": productID})
+		assert.Nil(t, err)
+	})
+}
@@ -11,17 +11,34 @@ import (
 	"github.com/labstack/gommon/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
-	c        *mongo.Client
-	db       *mongo.Database
-	col      *mongo.Collection
-	usersCol *mongo.Collection
-	cfg      config.Properties
-	h        ProductHandler
-	uh       UsersHandler
+	c          *mongo.Client
+	db         *mongo.Database
+	col        *mongo.Collection
+	usersCol   *mongo.Collection
+	suggestCol *mongo.Collection
+	revCol     *mongo.Collection
+	ratesCol   *mongo.Collection
+	promoCol   *mongo.Collection
+	stockCol   *mongo.Collection
+	whCol      *mongo.Collection
+	locCol     *mongo.Collection
+	vendorCol  *mongo.Collection
+	catCol     *mongo.Collection
+	changeCol  *mongo.Collection
+	images     *gridfs.Bucket
+	cfg        config.Properties
+	h          ProductHandler
+	uh         UsersHandler
+	rh         RatesHandler
+	ph         PromotionsHandler
+	wh         WarehousesHandler
+	vh         VendorsHandler
+	ch         CategoriesHandler
 )
 
 func init() {
@@ -37,6 +54,44 @@ func init() {
 	db = c.Database(cfg.DBName)
 	col = db.Collection(cfg.ProductCollection)
 	usersCol = db.Collection(cfg.UsersCollection)
+	suggestCol = db.Collection(cfg.SuggestCollection)
+	h.SuggestCol = suggestCol
+	revCol = db.Collection(cfg.RevCollection)
+	h.RevCol = revCol
+	ratesCol = db.Collection(cfg.RatesCollection)
+	h.RateCol = ratesCol
+	h.BaseCurrency = cfg.BaseCurrency
+	rh.Col = ratesCol
+	rh.Base = cfg.BaseCurrency
+	promoCol = db.Collection(cfg.PromoCollection)
+	h.PromoCol = promoCol
+	ph.Col = promoCol
+	stockCol = db.Collection(cfg.StockCollection)
+	h.StockCol = stockCol
+	wh.InventoryCol = stockCol
+	whCol = db.Collection(cfg.WarehouseCollection)
+	locCol = db.Collection(cfg.LocationCollection)
+	h.LocationCol = locCol
+	wh.Col = whCol
+	wh.StockCol = locCol
+	wh.ProdCol = col
+	vendorCol = db.Collection(cfg.VendorCollection)
+	h.VendorCol = vendorCol
+	vh.Col = vendorCol
+	vh.ProdCol = col
+	catCol = db.Collection(cfg.CategoryCollection)
+	h.CategoryCol = catCol
+	ch.Col = catCol
+	ch.ProdCol = col
+	changeCol = db.Collection(cfg.ChangeCollection)
+	h.ChangeCol = changeCol
+	ph.ChangeCol = changeCol
+	images, err = gridfs.NewBucket(db, options.GridFSBucket().SetName(cfg.ImageBucket))
+	if err != nil {
+		log.Fatalf("Unable to open the images bucket : %v", err)
+	}
+	h.Images = images
+	h.MaxImageSize = cfg.MaxImageSize
 	isUserIndexUnique := true
 	indexModel := mongo.IndexModel{
 		Keys: bson.M{"username": 1},
@@ -48,15 +103,80 @@ func init() {
 	if err != nil {
 		log.Fatalf("Unable to create an index : %+v", err)
 	}
+	textIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "product_name", Value: "text"},
+			{Key: "vendor", Value: "text"},
+			{Key: "accessories", Value: "text"},
+		},
+	}
+	_, err = col.Indexes().CreateOne(context.Background(), textIndexModel)
+	if err != nil {
+		log.Fatalf("Unable to create an index : %+v", err)
+	}
+	suggestIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "prefixes", Value: 1},
+			{Key: "count", Value: -1},
+			{Key: "updated_at", Value: -1},
+		},
+	}
+	_, err = suggestCol.Indexes().CreateOne(context.Background(), suggestIndexModel)
+	if err != nil {
+		log.Fatalf("Unable to create an index : %+v", err)
+	}
+	_, err = revCol.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "rev", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatalf("Unable to create an index : %+v", err)
+	}
+	_, err = locCol.Indexes().CreateOne(context.Background(), mongo.IndexModel{Keys: bson.M{"location": "2dsphere"}})
+	if err != nil {
+		log.Fatalf("Unable to create an index : %+v", err)
+	}
+	_, err = col.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.M{"variants.sku": 1},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"variants.sku": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		log.Fatalf("Unable to create an index : %+v", err)
+	}
+	_, err = vendorCol.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.M{"slug": 1},
+		Options: &options.IndexOptions{Unique: &isUserIndexUnique},
+	})
+	if err != nil {
+		log.Fatalf("Unable to create an index : %+v", err)
+	}
 }
 
 func TestMain(m *testing.M) {
 	ctx := context.Background()
 	//set up
+	var vendors []interface{}
+	for _, slug := range []string{"apple", "belkin", "google", "sony"} {
+		vendors = append(vendors, Vendor{Name: slug, Slug: slug, Status: vendorActive, CreatedAt: now(), UpdatedAt: now()})
+	}
+	if _, err := vendorCol.InsertMany(ctx, vendors); err != nil {
+		log.Fatalf("Unable to insert the vendors : %+v", err)
+	}
 	testCode := m.Run()
 	//destory
 	usersCol.Drop(ctx)
 	col.Drop(ctx)
+	revCol.Drop(ctx)
+	ratesCol.Drop(ctx)
+	promoCol.Drop(ctx)
+	stockCol.Drop(ctx)
+	whCol.Drop(ctx)
+	locCol.Drop(ctx)
+	vendorCol.Drop(ctx)
+	catCol.Drop(ctx)
+	changeCol.Drop(ctx)
+	images.Drop()
 	db.Drop(ctx)
 	os.Exit(testCode)
 }
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	headerETag        = "ETag"
+	headerIfMatch     = "If-Match"
+	headerIfNoneMatch = "If-None-Match"
+	headerVersion     = "X-Version"
+)
+
+//productETag is the strong entity tag of a product at version
+func productETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+//matchesETag reports whether a comma separated If-Match list accepts etag.
+//If-Match uses the strong comparison, so weak tags never match.
+func matchesETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+//listETag is a weak entity tag derived from the content of a listing
+func listETag(body interface{}, extra ...string) (string, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.New()
+	sum.Write(raw)
+	for _, e := range extra {
+		sum.Write([]byte(e))
+	}
+	return fmt.Sprintf(`W/"%x"`, sum.Sum(nil)), nil
+}
+
+//matchesWeakETag reports whether a comma separated If-None-Match list accepts etag,
+//using the weak comparison
+func matchesWeakETag(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+//notModified reports whether the conditional GET headers of r are satisfied by the
+//representation. If-None-Match takes precedence over If-Modified-Since.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get(headerIfNoneMatch); inm != "" {
+		return matchesWeakETag(inm, etag)
+	}
+	if ims := r.Header.Get(echo.HeaderIfModifiedSince); ims != "" && !modified.IsZero() {
+		since, err := http.ParseTime(ims)
+		return err == nil && !modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+//catalogChangeID is the change document of the product listings
+const catalogChangeID = "products"
+
+//touchCatalog records that the product listings changed now in a way the update times of the
+//products do not tell, such as a promotion deleted or products purged from the trash
+func touchCatalog(ctx context.Context, collection dbiface.CollectionAPI) error {
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": catalogChangeID},
+		bson.M{"$max": bson.M{"changed_at": now()}}, options.Update().SetUpsert(true))
+	return err
+}
+
+//latestTime is the latest time in field among the documents matching filter, zero if none
+func latestTime(ctx context.Context, filter bson.M, field string, collection dbiface.CollectionAPI) (time.Time, error) {
+	var doc bson.Raw
+	opts := options.FindOne().SetSort(bson.M{field: -1}).SetProjection(bson.M{field: 1})
+	err := collection.FindOne(ctx, filter, opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, _ := doc.Lookup(field).TimeOK()
+	return t, nil
+}
+
+//conditionalJSON sets the validators of body and answers 304 Not Modified when the
+//client already has it
+func conditionalJSON(c echo.Context, etag string, modified time.Time, body interface{}) error {
+	c.Response().Header().Set(headerETag, etag)
+	if !modified.IsZero() {
+		c.Response().Header().Set(echo.HeaderLastModified, modified.UTC().Format(http.TimeFormat))
+	}
+	if notModified(c.Request(), etag, modified) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSON(http.StatusOK, body)
+}
+
+//checkIfMatch fails with 412 Precondition Failed when the If-Match header does not
+//accept the current version of the product, an empty header accepts any version
+func checkIfMatch(ifMatch string, version int64) *echo.HTTPError {
+	if ifMatch == "" || matchesETag(ifMatch, productETag(version)) {
+		return nil
+	}
+	return echo.NewHTTPError(http.StatusPreconditionFailed,
+		errorMessage{Message: "product has been modified, fetch it again"})
+}
+
+//versionConflict is the error of a version guarded write which matched nothing because
+//someone else changed the product in between
+func versionConflict(ifMatch string) *echo.HTTPError {
+	if ifMatch != "" {
+		return echo.NewHTTPError(http.StatusPreconditionFailed,
+			errorMessage{Message: "product has been modified, fetch it again"})
+	}
+	return echo.NewHTTPError(http.StatusConflict,
+		errorMessage{Message: "product was modified concurrently, retry"})
+}
+
+//versionFilter matches the product only while it is still at version.
+//Products stored before versioning have no version field and are at version 0.
+func versionFilter(id primitive.ObjectID, version int64) bson.M {
+	if version == 0 {
+		return bson.M{"_id": id, "version": bson.M{"$in": bson.A{0, nil}}}
+	}
+	return bson.M{"_id": id, "version": version}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	//defaultPriceBuckets are the price range boundaries used when the client sends none
+	defaultPriceBuckets = "0,100,250,500,1000,2000"
+	maxPriceBuckets     = 20
+	otherBucket         = "other"
+)
+
+//facetBucket is the number of products sharing a value
+type facetBucket struct {
+	Value interface{} `json:"value" bson:"_id"`
+	Count int64       `json:"count" bson:"count"`
+}
+
+//priceRange is the number of products priced in [From, To).
+//Products outside of every range are counted in a range without bounds.
+type priceRange struct {
+	From  *float64 `json:"from,omitempty"`
+	To    *float64 `json:"to,omitempty"`
+	Count int64    `json:"count"`
+}
+
+//productFacets are the counts shown next to a product listing
+type productFacets struct {
+	Vendor      []facetBucket `json:"vendor" bson:"vendor"`
+	Currency    []facetBucket `json:"currency" bson:"currency"`
+	IsEssential []facetBucket `json:"is_essential" bson:"is_essential"`
+	Price       []priceRange  `json:"price" bson:"-"`
+	PriceCounts []facetBucket `json:"-" bson:"price"`
+}
+
+func parsePriceBuckets(raw string) ([]float64, *echo.HTTPError) {
+	if raw == "" {
+		raw = defaultPriceBuckets
+	}
+	parts := strings.Split(raw, ",")
+	if len(parts) < 2 || len(parts) > maxPriceBuckets {
+		return nil, echo.NewHTTPError(http.StatusBadRequest,
+			errorMessage{Message: fmt.Sprintf("price_buckets needs between 2 and %d boundaries", maxPriceBuckets)})
+	}
+	boundaries := make([]float64, 0, len(parts))
+	for i, part := range parts {
+		b, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || (i > 0 && b <= boundaries[i-1]) {
+			log.Errorf("Invalid price buckets : %s", raw)
+			return nil, echo.NewHTTPError(http.StatusBadRequest,
+				errorMessage{Message: "price_buckets must be ascending numbers"})
+		}
+		boundaries = append(boundaries, b)
+	}
+	return boundaries, nil
+}
+
+//priceRanges turns the $bucket output, keyed by lower boundary, into ranges
+func priceRanges(counts []facetBucket, boundaries []float64) []priceRange {
+	byLower := make(map[float64]int64)
+	ranges := []priceRange{}
+	for _, bucket := range counts {
+		if lower, ok := bucket.Value.(float64); ok {
+			byLower[lower] = bucket.Count
+			continue
+		}
+		ranges = append(ranges, priceRange{Count: bucket.Count})
+	}
+	for i := 0; i < len(boundaries)-1; i++ {
+		from, to := boundaries[i], boundaries[i+1]
+		ranges = append(ranges, priceRange{From: &from, To: &to, Count: byLower[from]})
+	}
+	return ranges
+}
+
+func facetProducts(ctx context.Context, text string, q url.Values, boundaries []float64, collection dbiface.CollectionAPI) (productFacets, *echo.HTTPError) {
+	var facets []productFacets
+	filter, httpError := productFilter(q)
+	if httpError != nil {
+		return productFacets{}, httpError
+	}
+	if text != "" {
+		filter["$text"] = bson.M{"$search": text}
+	}
+	pipeline := bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$facet": bson.M{
+			"vendor":       bson.A{bson.M{"$sortByCount": "$vendor"}},
+			"currency":     bson.A{bson.M{"$sortByCount": "$currency"}},
+			"is_essential": bson.A{bson.M{"$sortByCount": "$is_essential"}},
+			"price": bson.A{bson.M{"$bucket": bson.M{
+				"groupBy":    "$price",
+				"boundaries": boundaries,
+				"default":    otherBucket,
+				"output":     bson.M{"count": bson.M{"$sum": 1}},
+			}}},
+		}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Errorf("Unable to aggregate the products : %v", err)
+		return productFacets{},
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to compute the facets"})
+	}
+	if err = cursor.All(ctx, &facets); err != nil || len(facets) != 1 {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return productFacets{},
+			echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse the facets"})
+	}
+	facets[0].Price = priceRanges(facets[0].PriceCounts, boundaries)
+	return facets[0], nil
+}
+
+//FacetProducts counts products per vendor, currency, is_essential and price range.
+//It takes the filters of GetProducts, an optional text query q and price_buckets boundaries.
+//Like GetProducts it leaves the display currency out of the filters.
+func (h *ProductHandler) FacetProducts(c echo.Context) error {
+	boundaries, httpError := parsePriceBuckets(c.QueryParam("price_buckets"))
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	q := withoutKeys(c.QueryParams(), "q", "price_buckets", displayCurrencyParam)
+	facets, httpError := facetProducts(context.Background(), c.QueryParam("q"), q, boundaries, h.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	return c.JSON(http.StatusOK, facets)
+}
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // registers the gif decoder
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	//thumbnailSize is the largest side of a thumbnail in pixels
+	thumbnailSize = 256
+	//maxImagePixels guards the decoder against images which are small files but huge bitmaps
+	maxImagePixels = 50 * 1000 * 1000
+	//maxProductImages is the number of images a product can have
+	maxProductImages = 20
+)
+
+var (
+	imageTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+)
+
+//ImageMeta is the metadata stored along with an image in GridFS. Thumbnails refer to the
+//image they were made from with ThumbnailOf.
+type ImageMeta struct {
+	ContentType string              `json:"content_type" bson:"content_type"`
+	SHA256      string              `json:"sha256" bson:"sha256"`
+	Width       int                 `json:"width" bson:"width"`
+	Height      int                 `json:"height" bson:"height"`
+	ThumbnailOf *primitive.ObjectID `json:"thumbnail_of,omitempty" bson:"thumbnail_of,omitempty"`
+}
+
+//ProductImage is a GridFS file holding an image
+type ProductImage struct {
+	ID         primitive.ObjectID `json:"_id" bson:"_id"`
+	Filename   string             `json:"filename" bson:"filename"`
+	Length     int64              `json:"length" bson:"length"`
+	UploadDate time.Time          `json:"upload_date" bson:"uploadDate"`
+	Metadata   ImageMeta          `json:"metadata" bson:"metadata"`
+}
+
+func findImage(ctx context.Context, filter bson.M, bucket dbiface.BucketAPI) (ProductImage, bool, error) {
+	var images []ProductImage
+	cursor, err := bucket.Find(filter, options.GridFSFind().SetLimit(1))
+	if err != nil {
+		return ProductImage{}, false, err
+	}
+	if err := cursor.All(ctx, &images); err != nil {
+		return ProductImage{}, false, err
+	}
+	if len(images) == 0 {
+		return ProductImage{}, false, nil
+	}
+	return images[0], true, nil
+}
+
+//scaleDown shrinks src to fit in a size x size square with a box filter, averaging the
+//source pixels which fall into every destination pixel
+func scaleDown(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	dst := image.NewRGBA64(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+//thumbnail is a JPEG thumbnail of a JPEG image, or a PNG thumbnail of the other formats so
+//that transparency survives
+func thumbnail(src image.Image, format string) ([]byte, ImageMeta, error) {
+	var buf bytes.Buffer
+	var err error
+	thumb := scaleDown(src, thumbnailSize)
+	meta := ImageMeta{ContentType: "image/png", Width: thumb.Bounds().Dx(), Height: thumb.Bounds().Dy()}
+	if format == "jpeg" {
+		meta.ContentType = "image/jpeg"
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	meta.SHA256 = hex.EncodeToString(sum[:])
+	return buf.Bytes(), meta, err
+}
+
+//decodeImage sniffs the content type of data and decodes it, only JPEG, PNG and GIF images
+//are accepted
+func decodeImage(data []byte) (image.Image, string, string, *echo.HTTPError) {
+	contentType := http.DetectContentType(data)
+	if !imageTypes[contentType] {
+		return nil, "", "", echo.NewHTTPError(http.StatusUnsupportedMediaType,
+			errorMessage{Message: fmt.Sprintf("unsupported image type %s", contentType)})
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, "", "", echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "invalid image"})
+	}
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Errorf("Unable to decode the image : %v", err)
+		return nil, "", "", echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "invalid image"})
+	}
+	return src, format, contentType, nil
+}
+
+//storeImage stores an image and its thumbnail in GridFS. An image whose checksum is already
+//stored is not stored again, the existing one is returned and stored is false.
+func storeImage(ctx context.Context, filename string, data []byte, bucket dbiface.BucketAPI) (ProductImage, bool, *echo.HTTPError) {
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	existing, found, err := findImage(ctx, bson.M{"metadata.sha256": checksum, "metadata.thumbnail_of": nil}, bucket)
+	if err != nil {
+		log.Errorf("Unable to find the image : %v", err)
+		return existing, false, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to store the image"})
+	}
+	if found {
+		return existing, false, nil
+	}
+	src, format, contentType, httpError := decodeImage(data)
+	if httpError != nil {
+		return existing, false, httpError
+	}
+	thumb, thumbMeta, err := thumbnail(src, format)
+	if err != nil {
+		log.Errorf("Unable to make the thumbnail : %v", err)
+		return existing, false, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to make the thumbnail"})
+	}
+	meta := ImageMeta{ContentType: contentType, SHA256: checksum, Width: src.Bounds().Dx(), Height: src.Bounds().Dy()}
+	id, err := bucket.UploadFromStream(filename, bytes.NewReader(data), options.GridFSUpload().SetMetadata(meta))
+	if err != nil {
+		log.Errorf("Unable to upload the image : %v", err)
+		return existing, false, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to store the image"})
+	}
+	thumbMeta.ThumbnailOf = &id
+	_, err = bucket.UploadFromStream("thumbnail-"+filename, bytes.NewReader(thumb), options.GridFSUpload().SetMetadata(thumbMeta))
+	if err != nil {
+		log.Errorf("Unable to upload the thumbnail : %v", err)
+		if err := bucket.Delete(id); err != nil {
+			log.Errorf("Unable to delete the image : %v", err)
+		}
+		return existing, false, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to store the image"})
+	}
+	return ProductImage{ID: id, Filename: filename, Length: int64(len(data)), UploadDate: now(), Metadata: meta}, true, nil
+}
+
+func readUpload(fh *multipart.FileHeader) ([]byte, *echo.HTTPError) {
+	f, err := fh.Open()
+	if err != nil {
+		log.Errorf("Unable to open the upload : %v", err)
+		return nil, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "unable to read the image"})
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		log.Errorf("Unable to read the upload : %v", err)
+		return nil, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "unable to read the image"})
+	}
+	return data, nil
+}
+
+//AddImages stores the images of the multipart field images in GridFS and lists them on the
+//product. Images are sniffed for their content type, limited to MaxImageSize bytes each and
+//deduplicated by checksum. The images stored by a request which fails are deleted again,
+//unless another product lists them meanwhile.
+func (h *ProductHandler) AddImages(c echo.Context) error {
+	ctx := context.Background()
+	product, httpError := findProduct(ctx, c.Param("id"), h.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		log.Errorf("Unable to parse the multipart form : %v", err)
+		return c.JSON(http.StatusBadRequest, errorMessage{Message: "unable to parse the multipart form"})
+	}
+	files := form.File["images"]
+	if len(files) == 0 {
+		return c.JSON(http.StatusBadRequest, errorMessage{Message: "images are required"})
+	}
+	if len(product.Images)+len(files) > maxProductImages {
+		return c.JSON(http.StatusConflict, errorMessage{Message: fmt.Sprintf("a product has at most %d images", maxProductImages)})
+	}
+	for _, fh := range files {
+		if fh.Size > h.MaxImageSize {
+			return c.JSON(http.StatusRequestEntityTooLarge,
+				errorMessage{Message: fmt.Sprintf("%s is larger than %d bytes", fh.Filename, h.MaxImageSize)})
+		}
+	}
+	images := make([]ProductImage, 0, len(files))
+	ids := bson.A{}
+	var stored []primitive.ObjectID
+	discard := func() {
+		if err := purgeImages(ctx, stored, h.Col, h.Images); err != nil {
+			log.Errorf("Unable to delete the images : %v", err)
+		}
+	}
+	for _, fh := range files {
+		data, httpError := readUpload(fh)
+		if httpError != nil {
+			discard()
+			return c.JSON(httpError.Code, httpError.Message)
+		}
+		img, isNew, httpError := storeImage(ctx, fh.Filename, data, h.Images)
+		if httpError != nil {
+			discard()
+			return c.JSON(httpError.Code, httpError.Message)
+		}
+		if isNew {
+			stored = append(stored, img.ID)
+		}
+		images = append(images, img)
+		ids = append(ids, img.ID)
+	}
+	//the count is checked again by the update so that concurrent uploads cannot pass the cap
+	filter := bson.M{
+		"_id":        product.ID,
+		"deleted_at": nil,
+		"$expr": bson.M{"$lte": bson.A{
+			bson.M{"$size": bson.M{"$ifNull": bson.A{"$images", bson.A{}}}}, maxProductImages - len(ids),
+		}},
+	}
+	var updated Product
+	err = h.Col.FindOneAndUpdate(ctx, filter, bson.M{
+		"$addToSet": bson.M{"images": bson.M{"$each": ids}},
+		"$inc":      bson.M{"version": 1},
+		"$set":      bson.M{"updated_at": now()},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		discard()
+		return c.JSON(http.StatusConflict, errorMessage{Message: fmt.Sprintf("a product has at most %d images", maxProductImages)})
+	}
+	if err != nil {
+		log.Errorf("Unable to add the images to the product : %v", err)
+		discard()
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to add the images to the product"})
+	}
+	if httpError := recordRevision(c, revisionUpdate, &product, updated, h.RevCol); httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	c.Response().Header().Set(headerETag, productETag(updated.Version))
+	return c.JSON(http.StatusCreated, images)
+}
+
+//serveImage answers with an image of the product, or its thumbnail. Images never change so
+//they are cached for good, ranges and conditional requests are handled by http.ServeContent.
+func (h *ProductHandler) serveImage(c echo.Context, thumb bool) error {
+	ctx := context.Background()
+	product, httpError := findProduct(ctx, c.Param("id"), h.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	imageID, err := primitive.ObjectIDFromHex(c.Param("imageId"))
+	if err != nil || !containsID(product.Images, imageID) {
+		return c.JSON(http.StatusNotFound, errorMessage{Message: "unable to find the image"})
+	}
+	filter := bson.M{"_id": imageID}
+	if thumb {
+		filter = bson.M{"metadata.thumbnail_of": imageID}
+	}
+	img, found, err := findImage(ctx, filter, h.Images)
+	var data bytes.Buffer
+	if err == nil && found {
+		_, err = h.Images.DownloadToStream(img.ID, &data)
+	}
+	if err != nil {
+		log.Errorf("Unable to download the image : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to download the image"})
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, errorMessage{Message: "unable to find the image"})
+	}
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, img.Metadata.ContentType)
+	header.Set(headerETag, fmt.Sprintf(`"%s"`, img.Metadata.SHA256))
+	header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(c.Response(), c.Request(), img.Filename, img.UploadDate, bytes.NewReader(data.Bytes()))
+	return nil
+}
+
+//GetImage gets an image of a product, it supports range requests
+func (h *ProductHandler) GetImage(c echo.Context) error {
+	return h.serveImage(c, false)
+}
+
+//GetImageThumbnail gets the thumbnail of an image of a product
+func (h *ProductHandler) GetImageThumbnail(c echo.Context) error {
+	return h.serveImage(c, true)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func pngImage(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		img.Set(x, 0, color.RGBA{R: 255, A: 255})
+	}
+	err := png.Encode(&buf, img)
+	assert.Nil(t, err)
+	return buf.Bytes()
+}
+
+func uploadImages(t *testing.T, productID string, files map[string][]byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, data := range files {
+		part, err := mw.CreateFormFile("images", name)
+		assert.Nil(t, err)
+		part.Write(data)
+	}
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/products/%s/images", productID), &body)
+	req.Header.Set(echo.HeaderContentType, mw.FormDataContentType())
+	res := httptest.NewRecorder()
+	e := echo.New()
+	c := e.NewContext(req, res)
+	c.SetParamNames("id")
+	c.SetParamValues(productID)
+	h.Col = col
+	err := h.AddImages(c)
+	assert.Nil(t, err)
+	return res
+}
+
+func TestImages(t *testing.T) {
+	productID := primitive.NewObjectID()
+	var imageID string
+
+	t.Run("scale down", func(t *testing.T) {
+		thumb := scaleDown(image.NewRGBA(image.Rect(0, 0, 1000, 500)), thumbnailSize)
+		assert.Equal(t, image.Rect(0, 0, 256, 128), thumb.Bounds())
+		small := image.NewRGBA(image.Rect(0, 0, 10, 10))
+		assert.Equal(t, small, scaleDown(small, thumbnailSize))
+	})
+
+	t.Run("upload images", func(t *testing.T) {
+		price, _ := ParseMoney("10")
+		_, err := col.InsertOne(context.Background(), Product{ID: productID, Name: "camera", Price: price, Currency: "USD", Vendor: "sony"})
+		assert.Nil(t, err)
+
+		var uploaded []ProductImage
+		res := uploadImages(t, productID.Hex(), map[string][]byte{"front.png": pngImage(t, 600, 300)})
+		assert.Equal(t, http.StatusCreated, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &uploaded)
+		assert.Nil(t, err)
+		assert.Len(t, uploaded, 1)
+		assert.Equal(t, "image/png", uploaded[0].Metadata.ContentType)
+		imageID = uploaded[0].ID.Hex()
+
+		var again []ProductImage
+		res = uploadImages(t, productID.Hex(), map[string][]byte{"copy.png": pngImage(t, 600, 300)})
+		assert.Equal(t, http.StatusCreated, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &again)
+		assert.Nil(t, err)
+		assert.Equal(t, uploaded[0].ID, again[0].ID)
+
+		var product Product
+		err = col.FindOne(context.Background(), bson.M{"_id": productID}).Decode(&product)
+		assert.Nil(t, err)
+		assert.Equal(t, []primitive.ObjectID{uploaded[0].ID}, product.Images)
+	})
+
+	t.Run("upload images unhappy", func(t *testing.T) {
+		res := uploadImages(t, productID.Hex(), map[string][]byte{"notes.txt": []byte("not an image")})
+		assert.Equal(t, http.StatusUnsupportedMediaType, res.Code)
+
+		side := pngImage(t, 300, 600)
+		res = uploadImages(t, productID.Hex(), map[string][]byte{"side.png": side, "notes.txt": []byte("not an image")})
+		assert.Equal(t, http.StatusUnsupportedMediaType, res.Code)
+		sum := sha256.Sum256(side)
+		_, found, err := findImage(context.Background(), bson.M{"metadata.sha256": hex.EncodeToString(sum[:])}, h.Images)
+		assert.Nil(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("get an image range", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/products/id/images/imageId", nil)
+		req.Header.Set("Range", "bytes=0-7")
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id", "imageId")
+		c.SetParamValues(productID.Hex(), imageID)
+		err := h.GetImage(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusPartialContent, res.Code)
+		assert.Equal(t, "\x89PNG\r\n\x1a\n", res.Body.String())
+	})
+
+	t.Run("get a thumbnail", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/products/id/images/imageId/thumbnail", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id", "imageId")
+		c.SetParamValues(productID.Hex(), imageID)
+		err := h.GetImageThumbnail(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "image/png", res.Header().Get(echo.HeaderContentType))
+		cfg, err := png.DecodeConfig(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, 256, cfg.Width)
+		assert.Equal(t, 128, cfg.Height)
+
+		_, err = col.DeleteOne(context.Background(), bson.M{"_id": productID})
+		assert.Nil(t, err)
+	})
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//Stock is the inventory of a product. Available is OnHand minus Reserved, it is stored so that
+//every change can be guarded by a single atomic FindOneAndUpdate and none of them goes negative.
+type Stock struct {
+	ProductID primitive.ObjectID `json:"product_id" bson:"_id"`
+	OnHand    int64              `json:"on_hand" bson:"on_hand"`
+	Reserved  int64              `json:"reserved" bson:"reserved"`
+	Available int64              `json:"available" bson:"available"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+//stockAdjustment adds Delta, which may be negative, to the stock on hand
+type stockAdjustment struct {
+	Delta int64 `json:"delta" validate:"required"`
+}
+
+//stockReservation reserves or releases Quantity products
+type stockReservation struct {
+	Quantity int64 `json:"quantity" validate:"required,min=1"`
+}
+
+func findStock(ctx context.Context, productID primitive.ObjectID, collection dbiface.CollectionAPI) (Stock, *echo.HTTPError) {
+	stock := Stock{ProductID: productID}
+	err := collection.FindOne(ctx, bson.M{"_id": productID}).Decode(&stock)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Errorf("Unable to find the stock : %v", err)
+		return stock, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the stock"})
+	}
+	return stock, nil
+}
+
+//updateStock applies inc to the stock of a product when guard matches it, upsert creates a
+//missing stock, which is only safe for changes which cannot go negative
+func updateStock(ctx context.Context, productID primitive.ObjectID, guard, inc bson.M, upsert bool, collection dbiface.CollectionAPI) (Stock, *echo.HTTPError) {
+	var stock Stock
+	filter := bson.M{"_id": productID}
+	for k, v := range guard {
+		filter[k] = v
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetUpsert(upsert)
+	update := bson.M{"$inc": inc, "$set": bson.M{"updated_at": now()}}
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&stock)
+	if err == mongo.ErrNoDocuments {
+		return stock, echo.NewHTTPError(http.StatusConflict, errorMessage{Message: "not enough stock"})
+	}
+	if err != nil {
+		log.Errorf("Unable to update the stock : %v", err)
+		return stock, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to update the stock"})
+	}
+	return stock, nil
+}
+
+//adjustStock changes the stock on hand, it never removes reserved or missing products
+func adjustStock(ctx context.Context, productID primitive.ObjectID, delta int64, collection dbiface.CollectionAPI) (Stock, *echo.HTTPError) {
+	if delta < 0 {
+		return updateStock(ctx, productID, bson.M{"available": bson.M{"$gte": -delta}},
+			bson.M{"on_hand": delta, "available": delta}, false, collection)
+	}
+	return updateStock(ctx, productID, nil,
+		bson.M{"on_hand": delta, "available": delta, "reserved": 0}, true, collection)
+}
+
+//reserveStock sets aside quantity available products
+func reserveStock(ctx context.Context, productID primitive.ObjectID, quantity int64, collection dbiface.CollectionAPI) (Stock, *echo.HTTPError) {
+	return updateStock(ctx, productID, bson.M{"available": bson.M{"$gte": quantity}},
+		bson.M{"reserved": quantity, "available": -quantity}, false, collection)
+}
+
+//releaseStock makes quantity reserved products available again
+func releaseStock(ctx context.Context, productID primitive.ObjectID, quantity int64, collection dbiface.CollectionAPI) (Stock, *echo.HTTPError) {
+	return updateStock(ctx, productID, bson.M{"reserved": bson.M{"$gte": quantity}},
+		bson.M{"reserved": -quantity, "available": quantity}, false, collection)
+}
+
+//bindStockChange finds the product of the request and decodes the change into req
+func (h *ProductHandler) bindStockChange(c echo.Context, req interface{}) (Product, *echo.HTTPError) {
+	product, httpError := findProduct(context.Background(), c.Param("id"), h.Col)
+	if httpError != nil {
+		return product, httpError
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(req); err != nil {
+		log.Errorf("Unable to decode the stock change : %v", err)
+		return product, echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse request payload"})
+	}
+	if err := v.Struct(req); err != nil {
+		log.Errorf("Unable to validate the stock change : %v", err)
+		return product, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: err.Error()})
+	}
+	return product, nil
+}
+
+//AdjustStock adds delta to the stock on hand of a product, removing more than is available
+//answers 409 Conflict
+func (h *ProductHandler) AdjustStock(c echo.Context) error {
+	var req stockAdjustment
+	product, httpError := h.bindStockChange(c, &req)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	stock, httpError := adjustStock(context.Background(), product.ID, req.Delta, h.StockCol)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	return c.JSON(http.StatusOK, stock)
+}
+
+//ReserveStock reserves quantity products, reserving more than is available answers 409 Conflict
+func (h *ProductHandler) ReserveStock(c echo.Context) error {
+	var req stockReservation
+	product, httpError := h.bindStockChange(c, &req)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	stock, httpError := reserveStock(context.Background(), product.ID, req.Quantity, h.StockCol)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	return c.JSON(http.StatusOK, stock)
+}
+
+//ReleaseStock releases quantity reserved products, releasing more than is reserved answers
+//409 Conflict
+func (h *ProductHandler) ReleaseStock(c echo.Context) error {
+	var req stockReservation
+	product, httpError := h.bindStockChange(c, &req)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	stock, httpError := releaseStock(context.Background(), product.ID, req.Quantity, h.StockCol)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	return c.JSON(http.StatusOK, stock)
+}
+
+//includeAvailability reports whether ?include= asks for the availability of the product
+func includeAvailability(c echo.Context) (bool, *echo.HTTPError) {
+	include := false
+	for _, raw := range c.QueryParams()["include"] {
+		for _, name := range strings.Split(raw, ",") {
+			if name != "availability" {
+				return false, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "include only supports availability"})
+			}
+			include = true
+		}
+	}
+	return include, nil
+}
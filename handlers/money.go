@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+//maxMoneyDigits is the precision of a Decimal128
+const maxMoneyDigits = 34
+
+var (
+	moneyPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+	moneyType    = reflect.TypeOf(Money{})
+
+	//currencyExponents are the ISO 4217 currencies with the number of digits of their minor unit
+	currencyExponents = func() map[string]int {
+		exponents := make(map[string]int)
+		for _, code := range strings.Fields(`AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BMD BND BOB
+			BOV BRL BSD BTN BWP BYN BZD CAD CDF CHE CHF CHW CNY COP COU CRC CUC CUP CVE CZK DKK DOP DZD EGP
+			ERN ETB EUR FJD FKP GBP GEL GHS GIP GMD GTQ GYD HKD HNL HRK HTG HUF IDR ILS INR IRR JMD KES KGS
+			KHR KPW KYD KZT LAK LBP LKR LRD LSL MAD MDL MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MXV MYR MZN
+			NAD NGN NIO NOK NPR NZD PAB PEN PGK PHP PKR PLN QAR RON RSD RUB SAR SBD SCR SDG SEK SGD SHP SLE
+			SLL SOS SRD SSP STN SVC SYP SZL THB TJS TMT TOP TRY TTD TWD TZS UAH USD USN UYU UZS VED VES WST
+			XCD YER ZAR ZMW ZWL`) {
+			exponents[code] = 2
+		}
+		for _, code := range strings.Fields(`BIF CLP DJF GNF ISK JPY KMF KRW PYG RWF UGX UYI VND VUV XAF XOF XPF`) {
+			exponents[code] = 0
+		}
+		for _, code := range strings.Fields(`BHD IQD JOD KWD LYD OMR TND`) {
+			exponents[code] = 3
+		}
+		exponents["CLF"], exponents["UYW"] = 4, 4
+		return exponents
+	}()
+)
+
+//currencyExponent is the number of digits of the minor unit of an ISO 4217 currency
+func currencyExponent(currency string) (int, bool) {
+	exp, ok := currencyExponents[currency]
+	return exp, ok
+}
+
+//Money is an exact decimal amount. It is stored as a Decimal128 and written in JSON as a
+//string such as "12.50", JSON numbers are accepted on input. Integer and double prices
+//stored before Money existed are read as is, see MigratePrices to convert them.
+type Money struct {
+	coef *big.Int
+	exp  int
+}
+
+//ParseMoney parses a plain decimal number such as 12.50
+func ParseMoney(s string) (Money, error) {
+	if !moneyPattern.MatchString(s) {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+	exp := 0
+	if dot := strings.IndexByte(s, '.'); dot >= 0 {
+		exp = -(len(s) - dot - 1)
+		s = s[:dot] + s[dot+1:]
+	}
+	if len(strings.TrimLeft(strings.TrimPrefix(s, "-"), "0")) > maxMoneyDigits {
+		return Money{}, fmt.Errorf("amount has more than %d digits", maxMoneyDigits)
+	}
+	coef, _ := new(big.Int).SetString(s, 10)
+	return Money{coef: coef, exp: exp}, nil
+}
+
+//IsZero reports whether m is unset or zero
+func (m Money) IsZero() bool {
+	return m.coef == nil || m.coef.Sign() == 0
+}
+
+//Sign is -1, 0 or 1 depending on the sign of m
+func (m Money) Sign() int {
+	if m.coef == nil {
+		return 0
+	}
+	return m.coef.Sign()
+}
+
+//decimals is the number of significant digits after the decimal point
+func (m Money) decimals() int {
+	if m.coef == nil || m.exp >= 0 {
+		return 0
+	}
+	n := -m.exp
+	coef, rem := new(big.Int).Set(m.coef), new(big.Int)
+	ten := big.NewInt(10)
+	for n > 0 && coef.Sign() != 0 {
+		if coef.QuoRem(coef, ten, rem); rem.Sign() != 0 {
+			break
+		}
+		n--
+	}
+	if m.coef.Sign() == 0 {
+		return 0
+	}
+	return n
+}
+
+func (m Money) String() string {
+	if m.coef == nil {
+		return ""
+	}
+	s := new(big.Int).Abs(m.coef).String()
+	if m.exp >= 0 {
+		s += strings.Repeat("0", m.exp)
+	} else {
+		n := -m.exp
+		if len(s) <= n {
+			s = strings.Repeat("0", n-len(s)+1) + s
+		}
+		s = s[:len(s)-n] + "." + s[len(s)-n:]
+	}
+	if m.coef.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+//rat is the exact value of m
+func (m Money) rat() *big.Rat {
+	r := new(big.Rat)
+	if m.coef == nil {
+		return r
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(m.exp))), nil)
+	if m.exp >= 0 {
+		return r.SetInt(new(big.Int).Mul(m.coef, scale))
+	}
+	return r.SetFrac(m.coef, scale)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+//roundMoney rounds x to exponent decimals, halves away from zero
+func roundMoney(x *big.Rat, exponent int) Money {
+	x = new(big.Rat).Mul(x, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)))
+	num, den := new(big.Int).Abs(x.Num()), x.Denom()
+	twice := new(big.Int).Mul(den, big.NewInt(2))
+	coef := num.Mul(num, big.NewInt(2)).Add(num, den)
+	coef.Quo(coef, twice)
+	if x.Sign() < 0 {
+		coef.Neg(coef)
+	}
+	return Money{coef: coef, exp: -exponent}
+}
+
+//convert multiplies m by rate and rounds the result to exponent decimals, halves away from zero
+func (m Money) convert(rate float64, exponent int) Money {
+	return roundMoney(new(big.Rat).Mul(m.rat(), new(big.Rat).SetFloat64(rate)), exponent)
+}
+
+//MarshalJSON writes m as a JSON string, null when unset
+func (m Money) MarshalJSON() ([]byte, error) {
+	if m.coef == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(m.String())
+}
+
+//UnmarshalJSON reads m from a JSON string or number
+func (m *Money) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		*m = Money{}
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	}
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+//MarshalBSONValue stores m as a Decimal128, null when unset
+func (m Money) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if m.coef == nil {
+		return bsontype.Null, nil, nil
+	}
+	d, ok := primitive.ParseDecimal128FromBigInt(m.coef, m.exp)
+	if !ok {
+		return 0, nil, fmt.Errorf("amount %s does not fit a Decimal128", m)
+	}
+	return bsontype.Decimal128, bsoncore.AppendDecimal128(nil, d), nil
+}
+
+//UnmarshalBSONValue reads m from a Decimal128 or from a legacy integer or double price
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	value := bsoncore.Value{Type: t, Data: data}
+	var err error
+	switch t {
+	case bsontype.Null:
+		*m = Money{}
+	case bsontype.Decimal128:
+		var coef *big.Int
+		var exp int
+		if coef, exp, err = value.Decimal128().BigInt(); err == nil {
+			*m = Money{coef: coef, exp: exp}
+		}
+	case bsontype.Int32:
+		*m = Money{coef: big.NewInt(int64(value.Int32()))}
+	case bsontype.Int64:
+		*m = Money{coef: big.NewInt(value.Int64())}
+	case bsontype.Double:
+		*m, err = ParseMoney(strconv.FormatFloat(value.Double(), 'f', -1, 64))
+	default:
+		err = fmt.Errorf("cannot read a price from %s", t)
+	}
+	return err
+}
+
+//moneyValue lets the validator treat an unset or zero Money as missing
+func moneyValue(field reflect.Value) interface{} {
+	if m, ok := field.Interface().(Money); ok && !m.IsZero() {
+		return m.String()
+	}
+	return nil
+}
+
+//validateProduct checks the price against the currency: it must be a known ISO 4217
+//currency and the price may not have more decimals than its minor unit.
+//The variants are checked together by validateVariants.
+func validateProduct(sl validator.StructLevel) {
+	product := sl.Current().Interface().(Product)
+	exp, ok := currencyExponent(product.Currency)
+	if !ok {
+		sl.ReportError(product.Currency, "currency", "Currency", "iso4217", "")
+		return
+	}
+	if product.Price.Sign() < 0 {
+		sl.ReportError(product.Price, "price", "Price", "gte", "0")
+	}
+	if product.Price.decimals() > exp {
+		sl.ReportError(product.Price, "price", "Price", "exponent", strconv.Itoa(exp))
+	}
+	validateVariants(sl, product, exp)
+}
+
+//jsonValue is v with the Decimal128 values, which have no JSON form, written as strings
+func jsonValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case primitive.Decimal128:
+		return value.String()
+	case primitive.A:
+		values := make([]interface{}, len(value))
+		for i, item := range value {
+			values[i] = jsonValue(item)
+		}
+		return values
+	}
+	return v
+}
+
+//MigratePrices converts the integer and double prices stored before Money existed into
+//Decimal128. A double goes through its shortest string form, so 19.99 becomes 19.99 rather
+//than the binary approximation of it.
+func MigratePrices(ctx context.Context, collection dbiface.CollectionAPI) (int64, error) {
+	res, err := collection.UpdateMany(ctx,
+		bson.M{"price": bson.M{"$type": bson.A{"int", "long", "double"}}},
+		bson.A{bson.M{"$set": bson.M{"price": bson.M{"$toDecimal": bson.M{"$toString": "$price"}}}}})
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
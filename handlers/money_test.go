@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMoney(t *testing.T) {
+
+	t.Run("parse and format money", func(t *testing.T) {
+		for _, s := range []string{"0", "12.50", "-0.05", "1000", "0.001"} {
+			m, err := ParseMoney(s)
+			assert.Nil(t, err)
+			assert.Equal(t, s, m.String())
+		}
+		for _, s := range []string{"", "1e3", "12.", ".5", "abc", "1,5"} {
+			_, err := ParseMoney(s)
+			assert.NotNil(t, err, s)
+		}
+	})
+
+	t.Run("money decimals", func(t *testing.T) {
+		for s, decimals := range map[string]int{"12": 0, "12.50": 1, "12.05": 2, "0.000": 0, "-1.001": 3} {
+			m, err := ParseMoney(s)
+			assert.Nil(t, err)
+			assert.Equal(t, decimals, m.decimals(), s)
+		}
+	})
+
+	t.Run("money json", func(t *testing.T) {
+		var p struct {
+			Price Money `json:"price"`
+		}
+		for _, body := range []string{`{"price":"12.50"}`, `{"price":12.50}`} {
+			err := json.Unmarshal([]byte(body), &p)
+			assert.Nil(t, err)
+			raw, err := json.Marshal(p)
+			assert.Nil(t, err)
+			assert.Equal(t, `{"price":"12.50"}`, string(raw))
+		}
+		err := json.Unmarshal([]byte(`{"price":"twelve"}`), &p)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("money bson reads legacy prices", func(t *testing.T) {
+		var p struct {
+			Price Money `bson:"price"`
+		}
+		for legacy, want := range map[interface{}]string{int32(250): "250", int64(7): "7", 12.5: "12.5"} {
+			raw, err := bson.Marshal(bson.M{"price": legacy})
+			assert.Nil(t, err)
+			err = bson.Unmarshal(raw, &p)
+			assert.Nil(t, err)
+			assert.Equal(t, want, p.Price.String())
+		}
+		p.Price, _ = ParseMoney("19.99")
+		raw, err := bson.Marshal(p)
+		assert.Nil(t, err)
+		assert.Equal(t, "19.99", bson.Raw(raw).Lookup("price").Decimal128().String())
+	})
+
+	t.Run("convert money rounds half away from zero", func(t *testing.T) {
+		for _, tc := range []struct {
+			amount string
+			rate   float64
+			exp    int
+			want   string
+		}{
+			{"250", 1.0 / 75, 2, "3.33"},
+			{"1.25", 1, 1, "1.3"},
+			{"-1.25", 1, 1, "-1.3"},
+			{"100", 0.925, 0, "93"},
+			{"1", 0.5, 0, "1"},
+		} {
+			m, err := ParseMoney(tc.amount)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.want, m.convert(tc.rate, tc.exp).String(), tc.amount)
+		}
+	})
+}
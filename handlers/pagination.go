@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultPerPage int64 = 50
+	maxPerPage     int64 = 500
+)
+
+var (
+	//pageParams are query parameters which control paging and never become filters
+	pageParams = map[string]bool{
+		"limit":    true,
+		"offset":   true,
+		"page":     true,
+		"per_page": true,
+		"sort":     true,
+		"cursor":   true,
+	}
+)
+
+//pagination is the page of a listing requested by the client
+type pagination struct {
+	Limit  int64
+	Offset int64
+	Sort   bson.D
+	byPage bool
+	//keyset is set when the client pages with an opaque cursor instead of an offset
+	keyset bool
+	//after holds the sort key values of the last product seen, nil for the first page
+	after []bson.RawValue
+}
+
+//cursorPage is the body of a keyset page, NextCursor is empty on the last page
+type cursorPage struct {
+	Products   []Product `json:"products"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+//cursorToken is the decoded form of the opaque next_cursor token
+type cursorToken struct {
+	Sort   string          `bson:"s"`
+	Values []bson.RawValue `bson:"v"`
+}
+
+func parseCount(q url.Values, key string, min int64) (int64, bool, *echo.HTTPError) {
+	raw := q.Get(key)
+	if raw == "" {
+		return 0, false, nil
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n < min {
+		log.Errorf("Invalid value for %s : %s", key, raw)
+		return 0, false,
+			echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: fmt.Sprintf("%s must be an integer >= %d", key, min)})
+	}
+	return n, true, nil
+}
+
+func parseSort(raw string) (bson.D, *echo.HTTPError) {
+	var sort bson.D
+	if raw == "" {
+		return sort, nil
+	}
+	for _, key := range strings.Split(raw, ",") {
+		key = strings.TrimSpace(key)
+		order := 1
+		if strings.HasPrefix(key, "-") {
+			order = -1
+			key = key[1:]
+		} else if strings.HasPrefix(key, "+") {
+			key = key[1:]
+		}
+		if key == "" || strings.HasPrefix(key, "$") {
+			log.Errorf("Invalid sort key in : %s", raw)
+			return nil, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "invalid sort parameter"})
+		}
+		sort = append(sort, bson.E{Key: key, Value: order})
+	}
+	return sort, nil
+}
+
+//parsePagination reads limit/offset or page/per_page and sort from the query string
+func parsePagination(q url.Values) (pagination, *echo.HTTPError) {
+	p := pagination{Limit: defaultPerPage}
+	limit, hasLimit, httpError := parseCount(q, "limit", 1)
+	if httpError != nil {
+		return p, httpError
+	}
+	perPage, hasPerPage, httpError := parseCount(q, "per_page", 1)
+	if httpError != nil {
+		return p, httpError
+	}
+	offset, hasOffset, httpError := parseCount(q, "offset", 0)
+	if httpError != nil {
+		return p, httpError
+	}
+	page, hasPage, httpError := parseCount(q, "page", 1)
+	if httpError != nil {
+		return p, httpError
+	}
+	if (hasLimit || hasOffset) && (hasPage || hasPerPage) {
+		return p, echo.NewHTTPError(http.StatusBadRequest,
+			errorMessage{Message: "use either limit/offset or page/per_page, not both"})
+	}
+	switch {
+	case hasLimit:
+		p.Limit = limit
+	case hasPerPage:
+		p.Limit = perPage
+	}
+	if p.Limit > maxPerPage {
+		return p, echo.NewHTTPError(http.StatusBadRequest,
+			errorMessage{Message: fmt.Sprintf("page size cannot exceed %d", maxPerPage)})
+	}
+	p.Offset = offset
+	if hasPage || hasPerPage {
+		p.byPage = true
+		if hasPage {
+			p.Offset = (page - 1) * p.Limit
+		}
+	}
+	p.Sort, httpError = parseSort(q.Get("sort"))
+	if httpError != nil {
+		return p, httpError
+	}
+	if _, ok := q["cursor"]; ok {
+		if hasOffset || hasPage {
+			return p, echo.NewHTTPError(http.StatusBadRequest,
+				errorMessage{Message: "cursor cannot be combined with offset or page"})
+		}
+		p.keyset = true
+		p.Sort = keysetSort(p.Sort)
+		p.after, httpError = decodeCursor(q.Get("cursor"), p.Sort)
+	}
+	return p, httpError
+}
+
+//keysetSort makes _id the final sort key so that every product has a unique position
+func keysetSort(sort bson.D) bson.D {
+	for _, e := range sort {
+		if e.Key == "_id" {
+			return sort
+		}
+	}
+	return append(sort, bson.E{Key: "_id", Value: 1})
+}
+
+func sortSignature(sort bson.D) string {
+	keys := make([]string, 0, len(sort))
+	for _, e := range sort {
+		if e.Value == -1 {
+			keys = append(keys, "-"+e.Key)
+		} else {
+			keys = append(keys, e.Key)
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+func decodeCursor(raw string, sort bson.D) ([]bson.RawValue, *echo.HTTPError) {
+	if raw == "" {
+		return nil, nil
+	}
+	var token cursorToken
+	doc, err := base64.RawURLEncoding.DecodeString(raw)
+	if err == nil {
+		err = bson.Unmarshal(doc, &token)
+	}
+	if err != nil {
+		log.Errorf("Unable to decode the cursor : %v", err)
+		return nil, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "invalid cursor"})
+	}
+	if token.Sort != sortSignature(sort) || len(token.Values) != len(sort) {
+		return nil, echo.NewHTTPError(http.StatusBadRequest,
+			errorMessage{Message: "cursor does not match the requested sort"})
+	}
+	return token.Values, nil
+}
+
+//encodeCursor builds the token pointing right after the given raw document
+func encodeCursor(last bson.Raw, sort bson.D) (string, error) {
+	token := cursorToken{Sort: sortSignature(sort)}
+	for _, e := range sort {
+		val, err := last.LookupErr(strings.Split(e.Key, ".")...)
+		if err != nil {
+			val = bson.RawValue{Type: bsontype.Null}
+		}
+		token.Values = append(token.Values, val)
+	}
+	doc, err := bson.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(doc), nil
+}
+
+//afterFilter is a range condition matching the documents sorted after p.after.
+//A missing sort field is null, which sorts before any value: past a null come the non null
+//values in ascending order and nothing but nulls in descending order.
+func (p pagination) afterFilter() bson.M {
+	var or bson.A
+	for i, e := range p.Sort {
+		cond := bson.M{}
+		for j := 0; j < i; j++ {
+			cond[p.Sort[j].Key] = p.after[j]
+		}
+		null := p.after[i].Type == bsontype.Null
+		switch {
+		case null && e.Value == -1:
+			continue
+		case null:
+			cond[e.Key] = bson.M{"$ne": nil}
+		case e.Value == -1:
+			cond["$or"] = bson.A{bson.M{e.Key: bson.M{"$lt": p.after[i]}}, bson.M{e.Key: nil}}
+		default:
+			cond[e.Key] = bson.M{"$gt": p.after[i]}
+		}
+		or = append(or, cond)
+	}
+	return bson.M{"$or": or}
+}
+
+//keysetOptions fetches one extra document to find out whether there is a next page
+func (p pagination) keysetOptions() *options.FindOptions {
+	return options.Find().SetLimit(p.Limit + 1).SetSort(p.Sort)
+}
+
+//findOptions converts the page into mongo find options
+func (p pagination) findOptions() *options.FindOptions {
+	opts := options.Find().SetLimit(p.Limit).SetSkip(p.Offset)
+	if len(p.Sort) > 0 {
+		opts.SetSort(p.Sort)
+	}
+	return opts
+}
+
+func (p pagination) pageURL(base url.URL, offset int64) string {
+	q := base.Query()
+	for k := range pageParams {
+		if k != "sort" {
+			q.Del(k)
+		}
+	}
+	if p.byPage {
+		q.Set("page", strconv.FormatInt(offset/p.Limit+1, 10))
+		q.Set("per_page", strconv.FormatInt(p.Limit, 10))
+	} else {
+		q.Set("limit", strconv.FormatInt(p.Limit, 10))
+		q.Set("offset", strconv.FormatInt(offset, 10))
+	}
+	base.RawQuery = q.Encode()
+	return base.String()
+}
+
+//setCursorHeaders advertises the token of the next keyset page, if any
+func setCursorHeaders(c echo.Context, next string) {
+	if next == "" {
+		return
+	}
+	c.Response().Header().Set("X-Next-Cursor", next)
+	base := *c.Request().URL
+	base.Scheme = c.Scheme()
+	base.Host = c.Request().Host
+	q := base.Query()
+	q.Set("cursor", next)
+	base.RawQuery = q.Encode()
+	c.Response().Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, base.String()))
+}
+
+//setPageHeaders sets the X-Total-Count and RFC 5988 Link headers of a listing
+func setPageHeaders(c echo.Context, p pagination, total int64) {
+	c.Response().Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	base := *c.Request().URL
+	base.Scheme = c.Scheme()
+	base.Host = c.Request().Host
+	var links []string
+	link := func(offset int64, rel string) {
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, p.pageURL(base, offset), rel))
+	}
+	link(0, "first")
+	if p.Offset > 0 {
+		prev := p.Offset - p.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		link(prev, "prev")
+	}
+	if p.Offset+p.Limit < total {
+		link(p.Offset+p.Limit, "next")
+	}
+	if total > 0 {
+		link((total-1)/p.Limit*p.Limit, "last")
+	}
+	c.Response().Header().Set("Link", strings.Join(links, ", "))
+}
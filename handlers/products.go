@@ -1,73 +1,261 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/krunal4amity/tronicscorp/dbiface"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-//Product describes an electronic product e.g. phone
+//Product describes an electronic product e.g. phone.
+//Discount is a percentage always taken off the price, EffectivePrice is the price once it and
+//the running Promotions are taken off.
+//Accessories are plain names, AccessoryIDs refer to other products which ?expand=accessories
+//inlines as AccessoryProducts.
+//Images are GridFS files, they are only changed through the images endpoints.
+//Specs are checked against the attributes of the categories of the product.
 type Product struct {
-	ID          primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	Name        string             `json:"product_name" bson:"product_name" validate:"required,max=10"`
-	Price       int                `json:"price" bson:"price" validate:"required,max=2000"`
-	Currency    string             `json:"currency" bson:"currency" validate:"required,len=3"`
-	Discount    int                `json:"discount" bson:"discount"`
-	Vendor      string             `json:"vendor" bson:"vendor" validate:"required"`
-	Accessories []string           `json:"accessories,omitempty" bson:"accessories,omitempty"`
-	IsEssential bool               `json:"is_essential" bson:"is_essential"`
+	ID                primitive.ObjectID     `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name              string                 `json:"product_name" bson:"product_name" validate:"required,max=10"`
+	Price             Money                  `json:"price" bson:"price" validate:"required"`
+	Currency          string                 `json:"currency" bson:"currency" validate:"required,len=3"`
+	Discount          int                    `json:"discount" bson:"discount" validate:"min=0,max=100"`
+	Vendor            string                 `json:"vendor" bson:"vendor" validate:"required,slug"`
+	Categories        []primitive.ObjectID   `json:"categories,omitempty" bson:"categories,omitempty" validate:"max=20,unique"`
+	Accessories       []string               `json:"accessories,omitempty" bson:"accessories,omitempty"`
+	AccessoryIDs      []primitive.ObjectID   `json:"accessory_ids,omitempty" bson:"accessory_ids,omitempty" validate:"max=50,unique"`
+	Images            []primitive.ObjectID   `json:"images,omitempty" bson:"images,omitempty"`
+	Variants          []Variant              `json:"variants,omitempty" bson:"variants,omitempty" validate:"max=100,dive"`
+	Specs             map[string]interface{} `json:"specs,omitempty" bson:"specs,omitempty" validate:"max=50"`
+	IsEssential       bool                   `json:"is_essential" bson:"is_essential"`
+	Version           int64                  `json:"version" bson:"version"`
+	CreatedAt         time.Time              `json:"created_at" bson:"created_at"`
+	UpdatedAt         time.Time              `json:"updated_at" bson:"updated_at"`
+	DeletedAt         *time.Time             `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	DeletedBy         string                 `json:"deleted_by,omitempty" bson:"deleted_by,omitempty"`
+	EffectivePrice    *Money                 `json:"effective_price,omitempty" bson:"-"`
+	Promotions        []primitive.ObjectID   `json:"promotions,omitempty" bson:"-"`
+	Converted         *ConvertedPrice        `json:"converted,omitempty" bson:"-"`
+	Availability      *Stock                 `json:"availability,omitempty" bson:"-"`
+	AccessoryProducts []Product              `json:"accessory_products,omitempty" bson:"-"`
 }
 
-//ProductHandler a product handler
-type ProductHandler struct {
-	Col dbiface.CollectionAPI
+//now is the current time at the millisecond precision mongo stores, tests can set the clock
+var now = func() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
 }
 
-func findProducts(ctx context.Context, q url.Values, collection dbiface.CollectionAPI) ([]Product, *echo.HTTPError) {
-	var products []Product
-	filter := make(map[string]interface{})
-	for k, v := range q {
-		filter[k] = v[0]
+//lastModified is the most recent update time of products, including the exchange rates
+//their prices were converted with and their stock
+func lastModified(products ...Product) time.Time {
+	var latest time.Time
+	for _, product := range products {
+		if product.UpdatedAt.After(latest) {
+			latest = product.UpdatedAt
+		}
+		if product.Converted != nil && product.Converted.RatesAsOf != nil && product.Converted.RatesAsOf.After(latest) {
+			latest = *product.Converted.RatesAsOf
+		}
+		if product.Availability != nil && product.Availability.UpdatedAt.After(latest) {
+			latest = product.Availability.UpdatedAt
+		}
 	}
-	if filter["_id"] != nil {
-		docID, err := primitive.ObjectIDFromHex(filter["_id"].(string))
+	return latest
+}
+
+//listModified is the last time any product listing changed: the latest update of a product,
+//soft deletes and restores included, the latest change recorded by touchCatalog, the latest
+//start or end of a promotion and the exchange rates of the products. It is zero when it cannot
+//be found, the listing is then only validated by its entity tag.
+func (h *ProductHandler) listModified(ctx context.Context, products []Product) time.Time {
+	latest := lastModified(products...)
+	at := now()
+	for _, q := range []struct {
+		filter     bson.M
+		field      string
+		collection dbiface.CollectionAPI
+	}{
+		{bson.M{}, "updated_at", h.Col},
+		{bson.M{"_id": catalogChangeID}, "changed_at", h.ChangeCol},
+		{bson.M{"starts_at": bson.M{"$lte": at}}, "starts_at", h.PromoCol},
+		{bson.M{"ends_at": bson.M{"$lte": at}}, "ends_at", h.PromoCol},
+	} {
+		t, err := latestTime(ctx, q.filter, q.field, q.collection)
 		if err != nil {
-			log.Errorf("Unable to convert to Object ID : %v", err)
-			return products,
-				echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to convert to ObjectID"})
+			log.Errorf("Unable to find the last modification : %v", err)
+			return time.Time{}
 		}
-		filter["_id"] = docID
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+//ProductHandler a product handler
+type ProductHandler struct {
+	Col          dbiface.CollectionAPI
+	SuggestCol   dbiface.CollectionAPI
+	RevCol       dbiface.CollectionAPI
+	RateCol      dbiface.CollectionAPI
+	PromoCol     dbiface.CollectionAPI
+	StockCol     dbiface.CollectionAPI
+	LocationCol  dbiface.CollectionAPI
+	VendorCol    dbiface.CollectionAPI
+	CategoryCol  dbiface.CollectionAPI
+	ChangeCol    dbiface.CollectionAPI
+	Images       dbiface.BucketAPI
+	MaxImageSize int64
+	BaseCurrency string
+}
+
+//productFilter is the filter of the query string over the products which are not deleted
+func productFilter(q url.Values) (bson.M, *echo.HTTPError) {
+	filter, httpError := parseFilter(q, productFields)
+	if httpError != nil {
+		return nil, httpError
 	}
-	cursor, err := collection.Find(ctx, bson.M(filter))
+	filter["deleted_at"] = nil
+	return filter, nil
+}
+
+func findProducts(ctx context.Context, filter bson.M, p pagination, collection dbiface.CollectionAPI) ([]Product, int64, *echo.HTTPError) {
+	var products []Product
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Errorf("Unable to count the products : %v", err)
+		return products, 0,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to count the products"})
+	}
+	cursor, err := collection.Find(ctx, filter, p.findOptions())
 	if err != nil {
 		log.Errorf("Unable to find the products : %v", err)
-		return products,
+		return products, 0,
 			echo.NewHTTPError(http.StatusNotFound, errorMessage{Message: "unable to find the products"})
 	}
 	err = cursor.All(ctx, &products)
 	if err != nil {
 		log.Errorf("Unable to read the cursor : %v", err)
-		return products,
+		return products, 0,
 			echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse retrieved products"})
 	}
-	return products, nil
+	return products, total, nil
 }
 
-//GetProducts gets a list of products
+//findProductsAfter is the keyset variant of findProducts, it range scans past the cursor
+//instead of skipping so that iteration stays stable while products are inserted
+func findProductsAfter(ctx context.Context, filter bson.M, p pagination, collection dbiface.CollectionAPI) ([]Product, string, *echo.HTTPError) {
+	var products []Product
+	if p.after != nil {
+		filter = bson.M{"$and": bson.A{filter, p.afterFilter()}}
+	}
+	cursor, err := collection.Find(ctx, filter, p.keysetOptions())
+	if err != nil {
+		log.Errorf("Unable to find the products : %v", err)
+		return products, "",
+			echo.NewHTTPError(http.StatusNotFound, errorMessage{Message: "unable to find the products"})
+	}
+	defer cursor.Close(ctx)
+	var last bson.Raw
+	next := ""
+	for cursor.Next(ctx) {
+		if int64(len(products)) == p.Limit {
+			if next, err = encodeCursor(last, p.Sort); err != nil {
+				log.Errorf("Unable to encode the cursor : %v", err)
+				return products, "",
+					echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to encode the cursor"})
+			}
+			break
+		}
+		var product Product
+		if err := cursor.Decode(&product); err != nil {
+			log.Errorf("Unable to read the cursor : %v", err)
+			return products, "",
+				echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse retrieved products"})
+		}
+		last = append(last[:0], cursor.Current...)
+		products = append(products, product)
+	}
+	if err := cursor.Err(); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return products, "",
+			echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse retrieved products"})
+	}
+	return products, next, nil
+}
+
+//listProducts answers with a page of the products matching filter, see parsePagination for
+//the paging parameters. Passing cursor switches to keyset paging, the products then come in a
+//cursorPage whose next_cursor is also advertised in X-Next-Cursor. Prices are computed by
+//priceProducts.
+//It answers conditional requests with 304 Not Modified. Its Last-Modified is listModified
+//rather than the update times of the listed products, which do not tell that one left the page
+//or that a promotion changed their prices.
+func (h *ProductHandler) listProducts(c echo.Context, filter bson.M) error {
+	p, httpError := parsePagination(c.QueryParams())
+	if httpError == nil {
+		httpError = checkSort(p.Sort, productSortFields)
+	}
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if p.keyset {
+		products, next, httpError := findProductsAfter(context.Background(), filter, p, h.Col)
+		if httpError != nil {
+			return c.JSON(httpError.Code, httpError.Message)
+		}
+		if httpError := h.priceProducts(c, productRefs(products)...); httpError != nil {
+			return c.JSON(httpError.Code, httpError.Message)
+		}
+		setCursorHeaders(c, next)
+		page := cursorPage{Products: products, NextCursor: next}
+		if page.Products == nil {
+			page.Products = []Product{}
+		}
+		etag, err := listETag(page)
+		if err != nil {
+			log.Errorf("Unable to compute the etag : %v", err)
+			return c.JSON(http.StatusOK, page)
+		}
+		return conditionalJSON(c, etag, h.listModified(context.Background(), products), page)
+	}
+	products, total, httpError := findProducts(context.Background(), filter, p, h.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if httpError := h.priceProducts(c, productRefs(products)...); httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	setPageHeaders(c, p, total)
+	etag, err := listETag(products, strconv.FormatInt(total, 10))
+	if err != nil {
+		log.Errorf("Unable to compute the etag : %v", err)
+		return c.JSON(http.StatusOK, products)
+	}
+	return conditionalJSON(c, etag, h.listModified(context.Background(), products), products)
+}
+
+//GetProducts gets a page of products, see listProducts
 func (h *ProductHandler) GetProducts(c echo.Context) error {
-	products, httpError := findProducts(context.Background(), c.QueryParams(), h.Col)
+	filter, httpError := productFilter(withoutKeys(c.QueryParams(), displayCurrencyParam))
 	if httpError != nil {
 		return c.JSON(httpError.Code, httpError.Message)
 	}
-	return c.JSON(http.StatusOK, products)
+	return h.listProducts(c, filter)
 }
 
 func findProduct(ctx context.Context, id string, collection dbiface.CollectionAPI) (Product, *echo.HTTPError) {
@@ -78,7 +266,7 @@ func findProduct(ctx context.Context, id string, collection dbiface.CollectionAP
 		return product,
 			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to convert to ObjectID"})
 	}
-	res := collection.FindOne(ctx, bson.M{"_id": docID})
+	res := collection.FindOne(ctx, bson.M{"_id": docID, "deleted_at": nil})
 	err = res.Decode(&product)
 	if err != nil {
 		log.Errorf("Unable to find the product : %v", err)
@@ -88,122 +276,513 @@ func findProduct(ctx context.Context, id string, collection dbiface.CollectionAP
 	return product, nil
 }
 
-//GetProduct gets a single product
+//GetProduct gets a single product, it answers conditional requests with 304 Not Modified.
+//Prices are computed by priceProducts and include=availability adds the stock of the product,
+//expand=accessories inlines the products of its accessories.
+//The entity tag is weak and derived from the content when promotions, exchange rates, stock or
+//accessories changed the representation, which then has no Last-Modified either. X-Version
+//always carries the strong entity tag of the version, to be sent back in If-Match.
 func (h *ProductHandler) GetProduct(c echo.Context) error {
-	product, httpError := findProduct(context.Background(), c.Param("id"), h.Col)
+	withStock, httpError := includeAvailability(c)
 	if httpError != nil {
 		return c.JSON(httpError.Code, httpError.Message)
 	}
-	return c.JSON(http.StatusOK, product)
+	expand, httpError := expandAccessories(c)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	var product Product
+	if expand {
+		product, httpError = findExpandedProduct(context.Background(), c.Param("id"), h.Col)
+	} else {
+		product, httpError = findProduct(context.Background(), c.Param("id"), h.Col)
+	}
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	priced := append([]*Product{&product}, productRefs(product.AccessoryProducts)...)
+	if httpError := h.priceProducts(c, priced...); httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if withStock {
+		stock, httpError := findStock(context.Background(), product.ID, h.StockCol)
+		if httpError != nil {
+			return c.JSON(httpError.Code, httpError.Message)
+		}
+		product.Availability = &stock
+	}
+	c.Response().Header().Set(headerVersion, productETag(product.Version))
+	if len(product.Promotions) == 0 && product.Converted == nil && product.Availability == nil && !expand {
+		return conditionalJSON(c, productETag(product.Version), lastModified(product), product)
+	}
+	etag, err := listETag(product)
+	if err != nil {
+		log.Errorf("Unable to compute the etag : %v", err)
+		return c.JSON(http.StatusOK, product)
+	}
+	return conditionalJSON(c, etag, time.Time{}, product)
 }
 
-func deleteProduct(ctx context.Context, id string, collection dbiface.CollectionAPI) (int64, *echo.HTTPError) {
-	docID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		log.Errorf("Unable convert to ObjectID : %v", err)
-		return 0,
-			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to convert to ObjectID"})
+//deleteProduct moves a product to the trash, see PurgeDeletedProducts for the hard delete
+func deleteProduct(ctx context.Context, id, ifMatch, deletedBy string, collection dbiface.CollectionAPI) (Product, Product, int64, *echo.HTTPError) {
+	product, httpError := findProduct(ctx, id, collection)
+	previous := product
+	if httpError != nil {
+		if httpError.Code == http.StatusNotFound {
+			return product, previous, 0, nil
+		}
+		return product, previous, 0, httpError
+	}
+	if httpError := checkIfMatch(ifMatch, product.Version); httpError != nil {
+		return product, previous, 0, httpError
+	}
+	deletedAt := now()
+	update := bson.M{
+		"$set": bson.M{"deleted_at": deletedAt, "deleted_by": deletedBy, "updated_at": deletedAt},
+		"$inc": bson.M{"version": 1},
 	}
-	res, err := collection.DeleteOne(ctx, bson.M{"_id": docID})
+	res, err := collection.UpdateOne(ctx, versionFilter(product.ID, product.Version), update)
 	if err != nil {
 		log.Errorf("Unable to delete the product : %v", err)
-		return 0,
+		return product, previous, 0,
 			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to delete the product"})
 	}
-	return res.DeletedCount, nil
+	if res.ModifiedCount == 0 {
+		return product, previous, 0, versionConflict(ifMatch)
+	}
+	product.Version++
+	product.UpdatedAt = deletedAt
+	product.DeletedAt = &deletedAt
+	product.DeletedBy = deletedBy
+	return product, previous, res.ModifiedCount, nil
 }
 
-//DeleteProduct gets a single product
+//DeleteProduct moves a single product to the trash, honouring If-Match
 func (h *ProductHandler) DeleteProduct(c echo.Context) error {
-	delCount, httpError := deleteProduct(context.Background(), c.Param("id"), h.Col)
+	product, previous, delCount, httpError := deleteProduct(context.Background(), c.Param("id"),
+		c.Request().Header.Get(headerIfMatch), actor(c), h.Col)
 	if httpError != nil {
 		return c.JSON(httpError.Code, httpError.Message)
 	}
+	if delCount > 0 {
+		if httpError := recordRevision(c, revisionDelete, &previous, product, h.RevCol); httpError != nil {
+			return c.JSON(httpError.Code, httpError.Message)
+		}
+		if err := recordSuggestions(context.Background(), []Product{product}, -1, h.SuggestCol); err != nil {
+			log.Errorf("Unable to update the suggestions : %v", err)
+		}
+	}
 	return c.JSON(http.StatusOK, delCount)
 }
 
-func modifyProduct(ctx context.Context, id string, reqBody io.ReadCloser, collection dbiface.CollectionAPI) (Product, *echo.HTTPError) {
-	var product Product
+//checkReferences fails with 400 Bad Request when an update gives product a vendor, categories
+//or accessories which do not exist, the ones it already had are left alone. The specs are
+//always checked since the attributes of the categories may have changed.
+func checkReferences(ctx context.Context, product *Product, previous Product, collection, vendorCol, categoryCol dbiface.CollectionAPI) *echo.HTTPError {
+	if product.Vendor != previous.Vendor {
+		if httpError := checkVendor(ctx, product.Vendor, vendorCol); httpError != nil {
+			return httpError
+		}
+	}
+	if httpError := checkCategories(ctx, addedIDs(previous.Categories, product.Categories), categoryCol); httpError != nil {
+		return httpError
+	}
+	if httpError := checkAccessories(ctx, *product, previous, collection); httpError != nil {
+		return httpError
+	}
+	return checkSpecs(ctx, product, categoryCol)
+}
+
+func modifyProduct(ctx context.Context, id, ifMatch string, reqBody io.ReadCloser, collection, vendorCol, categoryCol dbiface.CollectionAPI) (Product, Product, *echo.HTTPError) {
+	var product, previous Product
 	//find if the product exits, if err return 404
 	docID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		log.Errorf("cannot convert to ObjectID :%v", err)
-		return product,
+		return product, previous,
 			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to convert to ObjectID"})
 	}
-	filter := bson.M{"_id": docID}
-	res := collection.FindOne(ctx, filter)
+	res := collection.FindOne(ctx, bson.M{"_id": docID, "deleted_at": nil})
 	if err := res.Decode(&product); err != nil {
 		log.Errorf("unable to decode to product :%v", err)
-		return product,
+		return product, previous,
 			echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to find the product"})
 	}
+	previous = product
+	if httpError := checkIfMatch(ifMatch, previous.Version); httpError != nil {
+		return product, previous, httpError
+	}
 
 	//decode the req payload, if err return 500
 	if err := json.NewDecoder(reqBody).Decode(&product); err != nil {
 		log.Errorf("unable to decode using reqbody : %v", err)
-		return product,
+		return product, previous,
 			echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse request payload"})
 	}
 
 	//validate the request, if err return 400
 	if err := v.Struct(product); err != nil {
 		log.Errorf("unable to validate the struct : %v", err)
-		return product,
+		return product, previous,
 			echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "unable to validate the request payload"})
 	}
+	if httpError := checkReferences(ctx, &product, previous, collection, vendorCol, categoryCol); httpError != nil {
+		return product, previous, httpError
+	}
 
-	//update the product, if err return 500
-	_, err = collection.UpdateOne(ctx, filter, bson.M{"$set": product})
+	//update the product unless someone else did in between, if err return 500
+	product.ID = previous.ID
+	product.Version = previous.Version + 1
+	product.CreatedAt = previous.CreatedAt
+	product.UpdatedAt = now()
+	product.DeletedAt, product.DeletedBy = nil, ""
+	product.Images = previous.Images
+	result, err := collection.UpdateOne(ctx, versionFilter(docID, previous.Version), bson.M{"$set": product})
+	if isDuplicateKey(err) {
+		return product, previous, echo.NewHTTPError(http.StatusConflict, errorMessage{Message: "a variant SKU is already taken"})
+	}
 	if err != nil {
 		log.Errorf("Unable to update the product : %v", err)
-		return product,
+		return product, previous,
 			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to update the product"})
 	}
-	return product, nil
+	if result.MatchedCount == 0 {
+		return product, previous, versionConflict(ifMatch)
+	}
+	return product, previous, nil
 }
 
-//UpdateProduct updates a product
+//UpdateProduct updates a product, honouring If-Match
 func (h *ProductHandler) UpdateProduct(c echo.Context) error {
-	product, httpError := modifyProduct(context.Background(), c.Param("id"), c.Request().Body, h.Col)
+	product, previous, httpError := modifyProduct(context.Background(), c.Param("id"),
+		c.Request().Header.Get(headerIfMatch), c.Request().Body, h.Col, h.VendorCol, h.CategoryCol)
 	if httpError != nil {
 		return c.JSON(httpError.Code, httpError.Message)
 	}
+	if httpError := recordRevision(c, revisionUpdate, &previous, product, h.RevCol); httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	refreshSuggestions(context.Background(), previous, product, h.SuggestCol)
+	c.Response().Header().Set(headerETag, productETag(product.Version))
 	return c.JSON(http.StatusOK, product)
 }
 
-func insertProducts(ctx context.Context, products []Product, collection dbiface.CollectionAPI) ([]interface{}, *echo.HTTPError) {
-	var insertedIds []interface{}
-	for _, product := range products {
-		product.ID = primitive.NewObjectID()
-		insertID, err := collection.InsertOne(ctx, product)
+//applyPatch applies a merge patch or a JSON patch, depending on contentType, to product
+func applyPatch(product Product, contentType string, reqBody io.Reader) (Product, *echo.HTTPError) {
+	var patched Product
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || (mediaType != MIMEMergePatch && mediaType != MIMEJSONPatch) {
+		return patched, echo.NewHTTPError(http.StatusUnsupportedMediaType,
+			errorMessage{Message: fmt.Sprintf("content type must be %s or %s", MIMEMergePatch, MIMEJSONPatch)})
+	}
+	var doc interface{}
+	raw, err := json.Marshal(product)
+	if err == nil {
+		err = json.Unmarshal(raw, &doc)
+	}
+	if err != nil {
+		log.Errorf("Unable to convert the product : %v", err)
+		return patched,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to patch the product"})
+	}
+	if mediaType == MIMEMergePatch {
+		var patch interface{}
+		if err = json.NewDecoder(reqBody).Decode(&patch); err == nil {
+			doc = mergePatch(doc, patch)
+		}
+	} else {
+		var ops []patchOp
+		if err = json.NewDecoder(reqBody).Decode(&ops); err == nil {
+			doc, err = jsonPatch(doc, ops)
+		}
+	}
+	if err != nil {
+		log.Errorf("Unable to apply the patch : %v", err)
+		return patched,
+			echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: fmt.Sprintf("unable to apply the patch: %v", err)})
+	}
+	raw, err = json.Marshal(doc)
+	if err != nil {
+		log.Errorf("Unable to convert the patched product : %v", err)
+		return patched,
+			echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to apply the patch"})
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patched); err != nil {
+		log.Errorf("Unable to decode the patched product : %v", err)
+		return patched,
+			echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: fmt.Sprintf("patched product is invalid: %v", err)})
+	}
+	if patched.ID != product.ID {
+		return patched, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "_id cannot be patched"})
+	}
+	patched.Version = product.Version
+	patched.CreatedAt = product.CreatedAt
+	patched.Images = product.Images
+	patched.UpdatedAt = product.UpdatedAt
+	patched.DeletedAt, patched.DeletedBy = product.DeletedAt, product.DeletedBy
+	return patched, nil
+}
+
+func patchProduct(ctx context.Context, id, ifMatch, contentType string, reqBody io.Reader, collection, vendorCol, categoryCol dbiface.CollectionAPI) (Product, Product, *echo.HTTPError) {
+	previous, httpError := findProduct(ctx, id, collection)
+	if httpError != nil {
+		return previous, previous, httpError
+	}
+	if httpError := checkIfMatch(ifMatch, previous.Version); httpError != nil {
+		return previous, previous, httpError
+	}
+	product, httpError := applyPatch(previous, contentType, reqBody)
+	if httpError != nil {
+		return product, previous, httpError
+	}
+	if err := v.Struct(product); err != nil {
+		log.Errorf("unable to validate the struct : %v", err)
+		return product, previous,
+			echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "unable to validate the patched product"})
+	}
+	if httpError := checkReferences(ctx, &product, previous, collection, vendorCol, categoryCol); httpError != nil {
+		return product, previous, httpError
+	}
+	update, err := changedFields(previous, product)
+	if err != nil {
+		log.Errorf("Unable to compare the products : %v", err)
+		return product, previous,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to update the product"})
+	}
+	if len(update) == 0 {
+		return product, previous, nil
+	}
+	product.Version++
+	product.UpdatedAt = now()
+	update["$inc"] = bson.M{"version": 1}
+	if update["$set"] == nil {
+		update["$set"] = bson.M{}
+	}
+	update["$set"].(bson.M)["updated_at"] = product.UpdatedAt
+	res, err := collection.UpdateOne(ctx, versionFilter(product.ID, previous.Version), update)
+	if isDuplicateKey(err) {
+		return product, previous, echo.NewHTTPError(http.StatusConflict, errorMessage{Message: "a variant SKU is already taken"})
+	}
+	if err != nil {
+		log.Errorf("Unable to update the product : %v", err)
+		return product, previous,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to update the product"})
+	}
+	if res.MatchedCount == 0 {
+		return product, previous, versionConflict(ifMatch)
+	}
+	return product, previous, nil
+}
+
+//PatchProduct partially updates a product with a JSON Merge Patch or a JSON Patch,
+//only the fields which actually change are written. It honours If-Match.
+func (h *ProductHandler) PatchProduct(c echo.Context) error {
+	product, previous, httpError := patchProduct(context.Background(), c.Param("id"),
+		c.Request().Header.Get(headerIfMatch), c.Request().Header.Get(echo.HeaderContentType), c.Request().Body, h.Col, h.VendorCol, h.CategoryCol)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if product.Version != previous.Version {
+		if httpError := recordRevision(c, revisionUpdate, &previous, product, h.RevCol); httpError != nil {
+			return c.JSON(httpError.Code, httpError.Message)
+		}
+	}
+	refreshSuggestions(context.Background(), previous, product, h.SuggestCol)
+	c.Response().Header().Set(headerETag, productETag(product.Version))
+	return c.JSON(http.StatusOK, product)
+}
+
+//itemResult is the outcome of creating one product of a batch
+type itemResult struct {
+	Index  int         `json:"index"`
+	Status int         `json:"status"`
+	ID     interface{} `json:"_id,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+//batchStatus is the status shared by all results, or 207 Multi-Status when they differ
+func batchStatus(results []itemResult) int {
+	if len(results) == 0 {
+		return http.StatusCreated
+	}
+	for _, result := range results[1:] {
+		if result.Status != results[0].Status {
+			return http.StatusMultiStatus
+		}
+	}
+	return results[0].Status
+}
+
+//insertProducts inserts every product whose result is not yet failed with a single InsertMany.
+//In ordered mode the batch stops at the first failure and the products after it are
+//reported as 424 Failed Dependency, otherwise every valid product is attempted.
+func insertProducts(ctx context.Context, products []Product, results []itemResult, ordered bool, collection dbiface.CollectionAPI) []itemResult {
+	var docs []interface{}
+	var positions []int
+	for i := range products {
+		if results[i].Status != 0 {
+			if ordered {
+				for j := i + 1; j < len(results); j++ {
+					if results[j].Status == 0 {
+						results[j] = itemResult{Index: j, Status: http.StatusFailedDependency, Error: "not attempted"}
+					}
+				}
+				break
+			}
+			continue
+		}
+		products[i].ID = primitive.NewObjectID()
+		products[i].Version = 1
+		products[i].CreatedAt = now()
+		products[i].UpdatedAt = products[i].CreatedAt
+		products[i].Images = nil
+		products[i].DeletedAt, products[i].DeletedBy = nil, ""
+		docs = append(docs, products[i])
+		positions = append(positions, i)
+	}
+	if len(docs) == 0 {
+		return results
+	}
+	failed := make(map[int]mongo.WriteError)
+	_, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(ordered))
+	if bwe, ok := err.(mongo.BulkWriteException); ok {
+		log.Errorf("Unable to insert some products to Database:%v", bwe)
+		for _, we := range bwe.WriteErrors {
+			failed[we.Index] = we.WriteError
+		}
+	} else if err != nil {
+		log.Errorf("Unable to insert to Database:%v", err)
+		for _, i := range positions {
+			results[i] = itemResult{Index: i, Status: http.StatusInternalServerError, Error: "unable to insert to database"}
+		}
+		return results
+	}
+	stopped := false
+	for j, i := range positions {
+		we, isFailed := failed[j]
+		switch {
+		case stopped:
+			results[i] = itemResult{Index: i, Status: http.StatusFailedDependency, Error: "not attempted"}
+		case isFailed && we.Code == 11000:
+			results[i] = itemResult{Index: i, Status: http.StatusConflict, Error: we.Message}
+			stopped = ordered
+		case isFailed:
+			results[i] = itemResult{Index: i, Status: http.StatusInternalServerError, Error: we.Message}
+			stopped = ordered
+		default:
+			results[i] = itemResult{Index: i, Status: http.StatusCreated, ID: products[i].ID}
+		}
+	}
+	return results
+}
+
+//checkBatchReferences fails the valid products whose vendor is not an active vendor, which
+//are in unknown categories, which have unknown accessories or whose specs do not suit their
+//categories with 400 Bad Request
+func checkBatchReferences(ctx context.Context, products []Product, results []itemResult, collection, vendorCol, categoryCol dbiface.CollectionAPI) *echo.HTTPError {
+	var slugs []string
+	var categories, accessories []primitive.ObjectID
+	for i, product := range products {
+		if results[i].Status == 0 {
+			slugs = append(slugs, product.Vendor)
+			categories = append(categories, product.Categories...)
+			accessories = append(accessories, product.AccessoryIDs...)
+		}
+	}
+	if len(slugs) == 0 {
+		return nil
+	}
+	active, httpError := activeVendors(ctx, slugs, vendorCol)
+	if httpError != nil {
+		return httpError
+	}
+	existing, httpError := loadCategories(ctx, categories, categoryCol)
+	if httpError != nil {
+		return httpError
+	}
+	existingAccessories := make(map[primitive.ObjectID]bool)
+	if len(accessories) > 0 {
+		if existingAccessories, httpError = existingProducts(ctx, accessories, collection); httpError != nil {
+			return httpError
+		}
+	}
+	for i, product := range products {
+		if results[i].Status != 0 {
+			continue
+		}
+		if !active[product.Vendor] {
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = fmt.Sprintf("unknown vendor %s", product.Vendor)
+			continue
+		}
+		for _, id := range product.Categories {
+			if _, ok := existing[id]; !ok {
+				results[i].Status = http.StatusBadRequest
+				results[i].Error = fmt.Sprintf("unknown category %s", id.Hex())
+				break
+			}
+		}
+		if id, ok := unknownAccessory(product, existingAccessories); ok && results[i].Status == 0 {
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = fmt.Sprintf("unknown accessory %s", id.Hex())
+		}
+		if results[i].Status != 0 {
+			continue
+		}
+		specs, err := validateSpecs(product.Specs, specSchema(product.Categories, existing))
 		if err != nil {
-			log.Errorf("Unable to insert to Database:%v", err)
-			return nil,
-				echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to insert to database"})
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = fmt.Sprintf("invalid specs: %v", err)
+			continue
 		}
-		insertedIds = append(insertedIds, insertID.InsertedID)
+		products[i].Specs = specs
 	}
-	return insertedIds, nil
+	return nil
 }
 
-//CreateProducts create products on mongodb database
+//CreateProducts create products on mongodb database.
+//It answers with the result of every product, 207 Multi-Status if they are not all alike.
+//ordered=false attempts every valid product instead of stopping at the first failure.
 func (h *ProductHandler) CreateProducts(c echo.Context) error {
 	var products []Product
 	c.Echo().Validator = &ProductValidator{validator: v}
+	ordered := true
+	if raw := c.QueryParam("ordered"); raw != "" {
+		var err error
+		if ordered, err = strconv.ParseBool(raw); err != nil {
+			return c.JSON(http.StatusBadRequest, errorMessage{Message: "ordered must be true or false"})
+		}
+	}
 	if err := c.Bind(&products); err != nil {
 		log.Errorf("Unable to bind : %v", err)
 		return c.JSON(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse request payload"})
 	}
-	for _, product := range products {
+	results := make([]itemResult, len(products))
+	for i, product := range products {
+		results[i].Index = i
 		if err := c.Validate(product); err != nil {
 			log.Errorf("Unable to validate the product %+v %v", product, err)
-			return c.JSON(http.StatusBadRequest, errorMessage{Message: "unable to validate request payload"})
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = err.Error()
 		}
 	}
-	IDs, httpError := insertProducts(context.Background(), products, h.Col)
-	if httpError != nil {
+	if httpError := checkBatchReferences(context.Background(), products, results, h.Col, h.VendorCol, h.CategoryCol); httpError != nil {
 		return c.JSON(httpError.Code, httpError.Message)
 	}
-	return c.JSON(http.StatusCreated, IDs)
+	results = insertProducts(context.Background(), products, results, ordered, h.Col)
+	var created []Product
+	for i, result := range results {
+		if result.Status == http.StatusCreated {
+			created = append(created, products[i])
+			if httpError := recordRevision(c, revisionCreate, nil, products[i], h.RevCol); httpError != nil {
+				results[i].Status, results[i].Error = httpError.Code, "unable to record the revision"
+			}
+		}
+	}
+	if err := recordSuggestions(context.Background(), created, 1, h.SuggestCol); err != nil {
+		log.Errorf("Unable to update the suggestions : %v", err)
+	}
+	return c.JSON(batchStatus(results), results)
 }
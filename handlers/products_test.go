@@ -1,23 +1,28 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func TestProduct(t *testing.T) {
 	var docID string
 
 	t.Run("test create product", func(t *testing.T) {
-		var IDs []string
+		var results []itemResult
 		body := `
 		[{
 			"product_name":"googletalk",
@@ -37,13 +42,12 @@ func TestProduct(t *testing.T) {
 		assert.Nil(t, err)
 		assert.Equal(t, http.StatusCreated, res.Code)
 
-		err = json.Unmarshal(res.Body.Bytes(), &IDs)
+		err = json.Unmarshal(res.Body.Bytes(), &results)
 		assert.Nil(t, err)
-		docID = IDs[0] // assign the value to docID
-		t.Logf("IDs: %#+v\n", IDs)
-		for _, ID := range IDs {
-			assert.NotNil(t, ID)
-		}
+		assert.Len(t, results, 1)
+		assert.Equal(t, http.StatusCreated, results[0].Status)
+		docID = results[0].ID.(string) // assign the value to docID
+		t.Logf("results: %#+v\n", results)
 	})
 
 	t.Run("get products", func(t *testing.T) {
@@ -67,7 +71,7 @@ func TestProduct(t *testing.T) {
 
 	t.Run("get products with query params", func(t *testing.T) {
 		var products []Product
-		req := httptest.NewRequest(http.MethodGet, "/products?currency=INR&vendor=google", nil)
+		req := httptest.NewRequest(http.MethodGet, "/products?currency[eq]=INR&vendor=google", nil)
 		res := httptest.NewRecorder()
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		e := echo.New()
@@ -84,6 +88,276 @@ func TestProduct(t *testing.T) {
 		}
 	})
 
+	t.Run("get products with typed query operators", func(t *testing.T) {
+		var products []Product
+		req := httptest.NewRequest(http.MethodGet,
+			"/products?price[gte]=100&price[lt]=500&vendor[in]=apple,google&accessories[all]=charger&is_essential=false", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.GetProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		err = json.Unmarshal(res.Body.Bytes(), &products)
+		assert.Nil(t, err)
+		assert.Len(t, products, 1)
+	})
+
+	t.Run("get products with mistyped query value unhappy", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/products?price[gte]=cheap", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.GetProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("get products with unknown filter unhappy", func(t *testing.T) {
+		var body filterError
+		for _, query := range []string{"$where=sleep(1000)", "color=red", "price[$gt]=1", "variants=x", "sort=color", "sort=-variants"} {
+			req := httptest.NewRequest(http.MethodGet, "/products?"+query, nil)
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			h.Col = col
+			err := h.GetProducts(c)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusBadRequest, res.Code)
+			err = json.Unmarshal(res.Body.Bytes(), &body)
+			assert.Nil(t, err)
+			assert.Contains(t, body.AllowedFields, "price")
+			assert.Contains(t, body.AllowedFields, "created_at")
+			assert.Contains(t, body.AllowedFields, "specs.<attribute>")
+			assert.NotContains(t, body.AllowedFields, "variants")
+		}
+	})
+
+	t.Run("get products by update time", func(t *testing.T) {
+		for query, code := range map[string]int{
+			"updated_at[gte]=2020-01-01T00:00:00Z&sort=-updated_at": http.StatusOK,
+			"created_at[lt]=yesterday":                              http.StatusBadRequest,
+		} {
+			req := httptest.NewRequest(http.MethodGet, "/products?"+query, nil)
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			h.Col = col
+			err := h.GetProducts(c)
+			assert.Nil(t, err)
+			assert.Equal(t, code, res.Code, query)
+		}
+	})
+
+	t.Run("get products in another currency", func(t *testing.T) {
+		var products []Product
+		asOf := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+		err := saveRates(context.Background(), []ExchangeRate{{Currency: "INR", Rate: 75, UpdatedAt: asOf}}, ratesCol)
+		assert.Nil(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/products?currency=usd&vendor=google", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err = h.GetProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		err = json.Unmarshal(res.Body.Bytes(), &products)
+		assert.Nil(t, err)
+		assert.Len(t, products, 1)
+		assert.Equal(t, "250", products[0].Price.String())
+		assert.Equal(t, "USD", products[0].Converted.Currency)
+		assert.Equal(t, "3.33", products[0].Converted.Price.String())
+		assert.True(t, asOf.Equal(*products[0].Converted.RatesAsOf))
+	})
+
+	t.Run("get products in an unknown currency unhappy", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/products?currency=XYZ", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.GetProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("get products paginated", func(t *testing.T) {
+		var products []Product
+		req := httptest.NewRequest(http.MethodGet, "/products?limit=1&offset=0&sort=-price,product_name", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.GetProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "1", res.Header().Get("X-Total-Count"))
+		assert.Contains(t, res.Header().Get("Link"), `rel="first"`)
+		assert.NotContains(t, res.Header().Get("Link"), `rel="next"`)
+
+		err = json.Unmarshal(res.Body.Bytes(), &products)
+		assert.Nil(t, err)
+		assert.Len(t, products, 1)
+	})
+
+	t.Run("get products invalid page size unhappy", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/products?per_page=100000", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.GetProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("get products with cursor", func(t *testing.T) {
+		var page cursorPage
+		req := httptest.NewRequest(http.MethodGet, "/products?cursor=&limit=1&sort=price", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.GetProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Empty(t, res.Header().Get("X-Next-Cursor"))
+
+		err = json.Unmarshal(res.Body.Bytes(), &page)
+		assert.Nil(t, err)
+		assert.Len(t, page.Products, 1)
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("get products with invalid cursor unhappy", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/products?cursor=bm90LWEtY3Vyc29y", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.GetProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("search products", func(t *testing.T) {
+		var hits []productHit
+		req := httptest.NewRequest(http.MethodGet, "/products/search?q=charger&currency=INR", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.SearchProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "1", res.Header().Get("X-Total-Count"))
+
+		err = json.Unmarshal(res.Body.Bytes(), &hits)
+		assert.Nil(t, err)
+		assert.Len(t, hits, 1)
+		assert.Equal(t, "googletalk", hits[0].Name)
+		assert.True(t, hits[0].Score > 0)
+	})
+
+	t.Run("search products without text unhappy", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/products/search", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.SearchProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("facet products", func(t *testing.T) {
+		var facets productFacets
+		req := httptest.NewRequest(http.MethodGet, "/products/facets?vendor=google&price_buckets=0,250,500&currency=EUR", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.FacetProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		err = json.Unmarshal(res.Body.Bytes(), &facets)
+		assert.Nil(t, err)
+		assert.Equal(t, []facetBucket{{Value: "google", Count: 1}}, facets.Vendor)
+		assert.Len(t, facets.Price, 2)
+		assert.Equal(t, int64(1), facets.Price[1].Count)
+	})
+
+	t.Run("suggest products", func(t *testing.T) {
+		var suggestions []Suggestion
+		req := httptest.NewRequest(http.MethodGet, "/products/suggest?prefix=Goo&limit=5", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.SuggestProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		err = json.Unmarshal(res.Body.Bytes(), &suggestions)
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []Suggestion{
+			{Text: "googletalk", Kind: "product_name"},
+			{Text: "google", Kind: "vendor"},
+		}, suggestions)
+	})
+
+	t.Run("rebuild suggestions", func(t *testing.T) {
+		suggest := func() []Suggestion {
+			var suggestions []Suggestion
+			req := httptest.NewRequest(http.MethodGet, "/products/suggest?prefix=Goo&limit=5", nil)
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			err := h.SuggestProducts(c)
+			assert.Nil(t, err)
+			err = json.Unmarshal(res.Body.Bytes(), &suggestions)
+			assert.Nil(t, err)
+			return suggestions
+		}
+		clock := time.Now().UTC().Truncate(time.Millisecond)
+		defer func(restore func() time.Time) { now = restore }(now)
+		now = func() time.Time { return clock }
+		rebuild := func() {
+			clock = clock.Add(time.Second)
+			req := httptest.NewRequest(http.MethodPost, "/products/suggest/rebuild", nil)
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			h.Col = col
+			err := h.RebuildSuggestions(c)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, res.Code)
+		}
+		price, _ := ParseMoney("99")
+		res, err := col.InsertOne(context.Background(), Product{Name: "goodphone", Price: price, Currency: "USD", Vendor: "google"})
+		assert.Nil(t, err)
+		rebuild()
+		assert.ElementsMatch(t, []Suggestion{
+			{Text: "googletalk", Kind: "product_name"},
+			{Text: "goodphone", Kind: "product_name"},
+			{Text: "google", Kind: "vendor"},
+		}, suggest())
+
+		_, err = col.DeleteOne(context.Background(), bson.M{"_id": res.InsertedID})
+		assert.Nil(t, err)
+		rebuild()
+		assert.ElementsMatch(t, []Suggestion{
+			{Text: "googletalk", Kind: "product_name"},
+			{Text: "google", Kind: "vendor"},
+		}, suggest())
+	})
+
 	t.Run("get a product", func(t *testing.T) {
 		var product Product
 		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/products/%s", docID), nil)
@@ -96,11 +370,68 @@ func TestProduct(t *testing.T) {
 		err := h.GetProduct(c)
 		assert.Nil(t, err)
 		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, `"1"`, res.Header().Get("ETag"))
 		err = json.Unmarshal(res.Body.Bytes(), &product)
 		assert.Nil(t, err)
 		assert.Equal(t, "INR", product.Currency)
 	})
 
+	t.Run("get a product not modified", func(t *testing.T) {
+		for header, value := range map[string]string{
+			"If-None-Match":     `W/"1"`,
+			"If-Modified-Since": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat),
+		} {
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/products/%s", docID), nil)
+			req.Header.Set(header, value)
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			c.SetParamNames("id")
+			c.SetParamValues(docID)
+			h.Col = col
+			err := h.GetProduct(c)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusNotModified, res.Code)
+			assert.Empty(t, res.Body.Bytes())
+		}
+	})
+
+	t.Run("get products not modified", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/products", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.GetProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		etag := res.Header().Get("ETag")
+		assert.NotEmpty(t, etag)
+		modified := res.Header().Get("Last-Modified")
+		assert.NotEmpty(t, modified)
+
+		getProducts := func(header, value string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/products", nil)
+			req.Header.Set(header, value)
+			res := httptest.NewRecorder()
+			c := e.NewContext(req, res)
+			err := h.GetProducts(c)
+			assert.Nil(t, err)
+			return res
+		}
+		assert.Equal(t, http.StatusNotModified, getProducts("If-None-Match", etag).Code)
+		assert.Equal(t, http.StatusNotModified, getProducts("If-Modified-Since", modified).Code)
+
+		_, err = changeCol.UpdateOne(context.Background(), bson.M{"_id": catalogChangeID},
+			bson.M{"$set": bson.M{"changed_at": time.Now().Add(time.Hour)}}, options.Update().SetUpsert(true))
+		assert.Nil(t, err)
+		res = getProducts("If-Modified-Since", modified)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.NotEqual(t, modified, res.Header().Get("Last-Modified"))
+		_, err = changeCol.DeleteOne(context.Background(), bson.M{"_id": catalogChangeID})
+		assert.Nil(t, err)
+	})
+
 	t.Run("put product", func(t *testing.T) {
 		var product Product
 		body := `
@@ -115,6 +446,7 @@ func TestProduct(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/products/%s", docID), strings.NewReader(body))
 		res := httptest.NewRecorder()
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("If-Match", `"1"`)
 		e := echo.New()
 		c := e.NewContext(req, res)
 		c.SetParamNames("id")                      // this is a hack
@@ -123,9 +455,176 @@ func TestProduct(t *testing.T) {
 		err := h.UpdateProduct(c)
 		assert.Nil(t, err)
 		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, `"2"`, res.Header().Get("ETag"))
 		err = json.Unmarshal(res.Body.Bytes(), &product)
 		assert.Nil(t, err)
 		assert.Equal(t, "USD", product.Currency)
+		assert.Equal(t, int64(2), product.Version)
+	})
+
+	t.Run("put product with stale etag unhappy", func(t *testing.T) {
+		body := `
+		{
+			"product_name":"googletalk",
+			"price":300,
+			"currency":"USD",
+			"vendor":"google"
+		}
+		`
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/products/%s", docID), strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("If-Match", `"1"`)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(docID)
+		h.Col = col
+		err := h.UpdateProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusPreconditionFailed, res.Code)
+	})
+
+	t.Run("merge patch product", func(t *testing.T) {
+		var product Product
+		body := `{"discount":10,"accessories":null}`
+		req := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/products/%s", docID), strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, MIMEMergePatch)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(docID)
+		h.Col = col
+		err := h.PatchProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &product)
+		assert.Nil(t, err)
+		assert.Equal(t, 10, product.Discount)
+		assert.Empty(t, product.Accessories)
+		assert.Equal(t, "USD", product.Currency)
+	})
+
+	t.Run("json patch product", func(t *testing.T) {
+		var product Product
+		body := `[
+			{"op":"test","path":"/currency","value":"USD"},
+			{"op":"replace","path":"/currency","value":"EUR"},
+			{"op":"add","path":"/accessories","value":["case"]}
+		]`
+		req := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/products/%s", docID), strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, MIMEJSONPatch)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(docID)
+		h.Col = col
+		err := h.PatchProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &product)
+		assert.Nil(t, err)
+		assert.Equal(t, "EUR", product.Currency)
+		assert.Equal(t, []string{"case"}, product.Accessories)
+	})
+
+	t.Run("patch product invalid unhappy", func(t *testing.T) {
+		for contentType, body := range map[string]string{
+			MIMEMergePatch:           `{"currency":null}`,
+			MIMEJSONPatch:            `[{"op":"remove","path":"/vendor"}]`,
+			echo.MIMEApplicationJSON: `{"discount":5}`,
+		} {
+			req := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/products/%s", docID), strings.NewReader(body))
+			res := httptest.NewRecorder()
+			req.Header.Set(echo.HeaderContentType, contentType)
+			e := echo.New()
+			c := e.NewContext(req, res)
+			c.SetParamNames("id")
+			c.SetParamValues(docID)
+			h.Col = col
+			err := h.PatchProduct(c)
+			assert.Nil(t, err)
+			assert.NotEqual(t, http.StatusOK, res.Code)
+		}
+	})
+
+	t.Run("change stock", func(t *testing.T) {
+		for _, step := range []struct {
+			action string
+			body   string
+			code   int
+			stock  Stock
+		}{
+			{"adjust", `{"delta":5}`, http.StatusOK, Stock{OnHand: 5, Available: 5}},
+			{"reserve", `{"quantity":3}`, http.StatusOK, Stock{OnHand: 5, Reserved: 3, Available: 2}},
+			{"reserve", `{"quantity":3}`, http.StatusConflict, Stock{}},
+			{"release", `{"quantity":1}`, http.StatusOK, Stock{OnHand: 5, Reserved: 2, Available: 3}},
+			{"release", `{"quantity":3}`, http.StatusConflict, Stock{}},
+			{"adjust", `{"delta":-4}`, http.StatusConflict, Stock{}},
+			{"reserve", `{"quantity":0}`, http.StatusBadRequest, Stock{}},
+		} {
+			var stock Stock
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/products/%s/stock/%s", docID, step.action),
+				strings.NewReader(step.body))
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			c.SetParamNames("id")
+			c.SetParamValues(docID)
+			h.Col = col
+			var err error
+			switch step.action {
+			case "adjust":
+				err = h.AdjustStock(c)
+			case "reserve":
+				err = h.ReserveStock(c)
+			case "release":
+				err = h.ReleaseStock(c)
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, step.code, res.Code, step.action+" "+step.body)
+			if step.code == http.StatusOK {
+				err = json.Unmarshal(res.Body.Bytes(), &stock)
+				assert.Nil(t, err)
+				assert.Equal(t, step.stock.OnHand, stock.OnHand)
+				assert.Equal(t, step.stock.Reserved, stock.Reserved)
+				assert.Equal(t, step.stock.Available, stock.Available)
+			}
+		}
+	})
+
+	t.Run("get a product with availability", func(t *testing.T) {
+		var product Product
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/products/%s?include=availability", docID), nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(docID)
+		h.Col = col
+		err := h.GetProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.True(t, strings.HasPrefix(res.Header().Get("ETag"), "W/"))
+		err = json.Unmarshal(res.Body.Bytes(), &product)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(3), product.Availability.Available)
+	})
+
+	t.Run("delete a product with stale etag unhappy", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/products/%s", docID), nil)
+		res := httptest.NewRecorder()
+		req.Header.Set("If-Match", `"1"`)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(docID)
+		h.Col = col
+		err := h.DeleteProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusPreconditionFailed, res.Code)
 	})
 
 	t.Run("delete a product", func(t *testing.T) {
@@ -144,4 +643,395 @@ func TestProduct(t *testing.T) {
 		assert.Nil(t, err)
 		assert.Equal(t, int64(1), delCount)
 	})
+
+	t.Run("get a deleted product unhappy", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/products/%s", docID), nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(docID)
+		h.Col = col
+		err := h.GetProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("get trash", func(t *testing.T) {
+		var products []Product
+		req := httptest.NewRequest(http.MethodGet, "/products/trash", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.GetTrash(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &products)
+		assert.Nil(t, err)
+		assert.Len(t, products, 1)
+		assert.Equal(t, docID, products[0].ID.Hex())
+		assert.NotNil(t, products[0].DeletedAt)
+	})
+
+	t.Run("restore a product", func(t *testing.T) {
+		var product Product
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/products/%s/restore", docID), nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(docID)
+		h.Col = col
+		err := h.RestoreProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &product)
+		assert.Nil(t, err)
+		assert.Nil(t, product.DeletedAt)
+	})
+
+	t.Run("get product history", func(t *testing.T) {
+		var revisions []Revision
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/products/%s/history", docID), nil)
+		res := httptest.NewRecorder()
+		req.Header.Set(correlationIDHeader, "history-test")
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(docID)
+		h.Col = col
+		err := h.GetProductHistory(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &revisions)
+		assert.Nil(t, err)
+		assert.Len(t, revisions, 6)
+		assert.Equal(t, "6", res.Header().Get("X-Total-Count"))
+		assert.Equal(t, revisionRestore, revisions[0].Op)
+		assert.Equal(t, int64(6), revisions[0].Rev)
+		assert.Equal(t, revisionCreate, revisions[5].Op)
+		assert.Equal(t, "USD", revisions[4].Diff["currency"].To)
+		assert.Equal(t, "INR", revisions[4].Diff["currency"].From)
+	})
+
+	t.Run("revert a product", func(t *testing.T) {
+		var product Product
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/products/%s/revert/2", docID), nil)
+		res := httptest.NewRecorder()
+		req.Header.Set("If-Match", `"6"`)
+		req.Header.Set(correlationIDHeader, "revert-test")
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id", "rev")
+		c.SetParamValues(docID, "2")
+		h.Col = col
+		err := h.RevertProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, `"7"`, res.Header().Get("ETag"))
+		err = json.Unmarshal(res.Body.Bytes(), &product)
+		assert.Nil(t, err)
+		assert.Equal(t, "USD", product.Currency)
+		assert.Equal(t, 0, product.Discount)
+		assert.Equal(t, []string{"charger", "subscription"}, product.Accessories)
+
+		var revision Revision
+		err = revCol.FindOne(context.Background(), map[string]interface{}{"product_id": product.ID, "rev": 7}).Decode(&revision)
+		assert.Nil(t, err)
+		assert.Equal(t, revisionRevert, revision.Op)
+		assert.Equal(t, "revert-test", revision.CorrelationID)
+	})
+
+	t.Run("revert to an unknown revision unhappy", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/products/%s/revert/99", docID), nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id", "rev")
+		c.SetParamValues(docID, "99")
+		h.Col = col
+		err := h.RevertProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("revert to a revision with an unknown vendor unhappy", func(t *testing.T) {
+		id, _ := primitive.ObjectIDFromHex(docID)
+		price, _ := ParseMoney("250")
+		_, err := revCol.InsertOne(context.Background(), Revision{ID: primitive.NewObjectID(), ProductID: id, Rev: 98, Op: revisionUpdate,
+			Snapshot: Product{ID: id, Name: "googletalk", Price: price, Currency: "USD", Vendor: "nokia"}})
+		assert.Nil(t, err)
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/products/%s/revert/98", docID), nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id", "rev")
+		c.SetParamValues(docID, "98")
+		h.Col = col
+		err = h.RevertProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("update a product whose revision is taken unhappy", func(t *testing.T) {
+		id, _ := primitive.ObjectIDFromHex(docID)
+		_, err := revCol.InsertOne(context.Background(), Revision{ID: primitive.NewObjectID(), ProductID: id, Rev: 8, Op: revisionUpdate})
+		assert.Nil(t, err)
+		body := `{"product_name":"googletalk","price":"260","currency":"USD","vendor":"google"}`
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/products/%s", docID), strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(docID)
+		h.Col = col
+		err = h.UpdateProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusInternalServerError, res.Code)
+	})
+
+	t.Run("purge deleted products", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/products/%s", docID), nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(docID)
+		h.Col = col
+		err := h.DeleteProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		purged, err := h.PurgeDeletedProducts(context.Background(), -time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), purged)
+		id, _ := primitive.ObjectIDFromHex(docID)
+		revisions, err := revCol.CountDocuments(context.Background(), bson.M{"product_id": id})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), revisions)
+	})
+
+	t.Run("create a product straight into the trash unhappy", func(t *testing.T) {
+		var results []itemResult
+		body := `[{"product_name":"ghost","price":"10","currency":"USD","vendor":"google","deleted_at":"2020-01-01T00:00:00Z","deleted_by":"admin"}]`
+		req := httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.CreateProducts(c)
+		assert.Nil(t, err)
+		err = json.Unmarshal(res.Body.Bytes(), &results)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, results[0].Status)
+
+		var product Product
+		id, _ := primitive.ObjectIDFromHex(results[0].ID.(string))
+		err = col.FindOne(context.Background(), bson.M{"_id": id}).Decode(&product)
+		assert.Nil(t, err)
+		assert.Nil(t, product.DeletedAt)
+		assert.Empty(t, product.DeletedBy)
+		_, err = col.DeleteOne(context.Background(), bson.M{"_id": id})
+		assert.Nil(t, err)
+	})
+
+	t.Run("test create products partially unhappy", func(t *testing.T) {
+		var results []itemResult
+		body := `
+		[{
+			"product_name":"pixel",
+			"price":700,
+			"currency":"USD",
+			"vendor":"google"
+		},
+		{
+			"product_name":"nocurrency",
+			"price":100,
+			"vendor":"google"
+		}]
+		`
+		req := httptest.NewRequest(http.MethodPost, "/products?ordered=false", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.CreateProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusMultiStatus, res.Code)
+
+		err = json.Unmarshal(res.Body.Bytes(), &results)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, results[0].Status)
+		assert.NotNil(t, results[0].ID)
+		assert.Equal(t, http.StatusBadRequest, results[1].Status)
+		assert.NotEmpty(t, results[1].Error)
+	})
+
+	t.Run("test create products ordered stops at first failure", func(t *testing.T) {
+		var results []itemResult
+		body := `
+		[{
+			"product_name":"nocurrency",
+			"price":100,
+			"vendor":"google"
+		},
+		{
+			"product_name":"pixel",
+			"price":700,
+			"currency":"USD",
+			"vendor":"google"
+		}]
+		`
+		req := httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.CreateProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusMultiStatus, res.Code)
+
+		err = json.Unmarshal(res.Body.Bytes(), &results)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, results[0].Status)
+		assert.Equal(t, http.StatusFailedDependency, results[1].Status)
+	})
+
+	t.Run("test create products with precise prices", func(t *testing.T) {
+		var results []itemResult
+		body := `
+		[
+			{"product_name":"headset","price":"19.99","currency":"USD","vendor":"sony"},
+			{"product_name":"walkman","price":"15000","currency":"JPY","vendor":"sony"},
+			{"product_name":"halfyen","price":"12.5","currency":"JPY","vendor":"sony"},
+			{"product_name":"millidinar","price":"1.001","currency":"USD","vendor":"sony"},
+			{"product_name":"unknown","price":"1","currency":"ABC","vendor":"sony"}
+		]
+		`
+		req := httptest.NewRequest(http.MethodPost, "/products?ordered=false", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.CreateProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusMultiStatus, res.Code)
+
+		err = json.Unmarshal(res.Body.Bytes(), &results)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, results[0].Status)
+		assert.Equal(t, http.StatusCreated, results[1].Status)
+		assert.Equal(t, http.StatusBadRequest, results[2].Status)
+		assert.Equal(t, http.StatusBadRequest, results[3].Status)
+		assert.Equal(t, http.StatusBadRequest, results[4].Status)
+
+		var product Product
+		err = col.FindOne(context.Background(), map[string]interface{}{"product_name": "headset"}).Decode(&product)
+		assert.Nil(t, err)
+		assert.Equal(t, "19.99", product.Price.String())
+	})
+
+	var pixelID string
+	t.Run("test create products of unknown vendors", func(t *testing.T) {
+		var results []itemResult
+		body := `
+		[
+			{"product_name":"pixel","price":"499","currency":"USD","vendor":"google"},
+			{"product_name":"nokia","price":"99","currency":"USD","vendor":"nokia"},
+			{"product_name":"badslug","price":"99","currency":"USD","vendor":"Not A Slug"}
+		]
+		`
+		req := httptest.NewRequest(http.MethodPost, "/products?ordered=false", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.CreateProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusMultiStatus, res.Code)
+
+		err = json.Unmarshal(res.Body.Bytes(), &results)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, results[0].Status)
+		pixelID = results[0].ID.(string)
+		assert.Equal(t, http.StatusBadRequest, results[1].Status)
+		assert.Equal(t, "unknown vendor nokia", results[1].Error)
+		assert.Equal(t, http.StatusBadRequest, results[2].Status)
+	})
+
+	t.Run("test update a product to an unknown vendor", func(t *testing.T) {
+		body := `{"vendor":"nokia"}`
+		req := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/products/%s", pixelID), strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, MIMEMergePatch)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(pixelID)
+		h.Col = col
+		err := h.PatchProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("test accessories", func(t *testing.T) {
+		create := func(body string) []itemResult {
+			var results []itemResult
+			req := httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(body))
+			res := httptest.NewRecorder()
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			e := echo.New()
+			c := e.NewContext(req, res)
+			h.Col = col
+			err := h.CreateProducts(c)
+			assert.Nil(t, err)
+			err = json.Unmarshal(res.Body.Bytes(), &results)
+			assert.Nil(t, err)
+			return results
+		}
+		charger := create(`[{"product_name":"charger","price":"19","currency":"USD","vendor":"google"}]`)[0].ID.(string)
+		results := create(fmt.Sprintf(`[
+			{"product_name":"pixel3","price":"599","currency":"USD","vendor":"google","accessory_ids":["%s"]},
+			{"product_name":"pixel4","price":"699","currency":"USD","vendor":"google","accessory_ids":["%s"]}
+		]`, charger, primitive.NewObjectID().Hex()))
+		assert.Equal(t, http.StatusCreated, results[0].Status)
+		assert.Equal(t, http.StatusBadRequest, results[1].Status)
+		pixel := results[0].ID.(string)
+
+		var product Product
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/products/%s?expand=accessories", pixel), nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(pixel)
+		err := h.GetProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &product)
+		assert.Nil(t, err)
+		assert.Len(t, product.AccessoryProducts, 1)
+		assert.Equal(t, "charger", product.AccessoryProducts[0].Name)
+
+		var compatible []Product
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/products/%s/compatible", charger), nil)
+		res = httptest.NewRecorder()
+		c = e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(charger)
+		err = h.GetCompatibleProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &compatible)
+		assert.Nil(t, err)
+		assert.Len(t, compatible, 1)
+		assert.Equal(t, pixel, compatible[0].ID.Hex())
+	})
 }
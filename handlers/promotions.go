@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+const (
+	promoPercentage = "percentage"
+	promoFixed      = "fixed"
+	promoBuyXGetY   = "buy_x_get_y"
+)
+
+//promoOrder is the order in which stackable promotions apply
+var promoOrder = map[string]int{promoPercentage: 0, promoBuyXGetY: 1, promoFixed: 2}
+
+//Promotion lowers the effective price of the products it targets while it runs.
+//A percentage promotion takes Percent off, a fixed one takes Amount off the products priced
+//in Currency and a buy_x_get_y one gives Get products for every Buy, i.e. the unit price is
+//multiplied by Buy/(Buy+Get). It targets the products of Vendors and the products of
+//ProductIDs, or every product when both are empty. StartsAt and EndsAt bound it in time.
+//
+//Stackable promotions combine: percentages first, then buy_x_get_y, then fixed amounts.
+//A promotion which is not stackable never combines with another one, the single best of
+//them competes with the combination of the stackable ones and the lowest price wins.
+type Promotion struct {
+	ID         primitive.ObjectID   `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name       string               `json:"name" bson:"name" validate:"required,max=50"`
+	Kind       string               `json:"kind" bson:"kind" validate:"required,oneof=percentage fixed buy_x_get_y"`
+	Percent    int                  `json:"percent,omitempty" bson:"percent,omitempty" validate:"min=0,max=100"`
+	Amount     *Money               `json:"amount,omitempty" bson:"amount,omitempty"`
+	Currency   string               `json:"currency,omitempty" bson:"currency,omitempty"`
+	Buy        int                  `json:"buy,omitempty" bson:"buy,omitempty" validate:"min=0"`
+	Get        int                  `json:"get,omitempty" bson:"get,omitempty" validate:"min=0"`
+	Vendors    []string             `json:"vendors,omitempty" bson:"vendors,omitempty"`
+	ProductIDs []primitive.ObjectID `json:"product_ids,omitempty" bson:"product_ids,omitempty"`
+	StartsAt   *time.Time           `json:"starts_at,omitempty" bson:"starts_at,omitempty"`
+	EndsAt     *time.Time           `json:"ends_at,omitempty" bson:"ends_at,omitempty"`
+	Stackable  bool                 `json:"stackable" bson:"stackable"`
+	CreatedAt  time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+//PromotionsHandler a promotions handler, ChangeCol records the changes of the product
+//listings which promotion writes cause
+type PromotionsHandler struct {
+	Col       dbiface.CollectionAPI
+	ChangeCol dbiface.CollectionAPI
+}
+
+//validatePromotion checks the fields each kind of promotion needs
+func validatePromotion(sl validator.StructLevel) {
+	promo := sl.Current().Interface().(Promotion)
+	switch promo.Kind {
+	case promoPercentage:
+		if promo.Percent < 1 {
+			sl.ReportError(promo.Percent, "percent", "Percent", "required", "")
+		}
+	case promoFixed:
+		if promo.Amount == nil || promo.Amount.Sign() <= 0 {
+			sl.ReportError(promo.Amount, "amount", "Amount", "gt", "0")
+			break
+		}
+		if exp, ok := currencyExponent(promo.Currency); !ok {
+			sl.ReportError(promo.Currency, "currency", "Currency", "iso4217", "")
+		} else if promo.Amount.decimals() > exp {
+			sl.ReportError(promo.Amount, "amount", "Amount", "exponent", strconv.Itoa(exp))
+		}
+	case promoBuyXGetY:
+		if promo.Buy < 1 {
+			sl.ReportError(promo.Buy, "buy", "Buy", "min", "1")
+		}
+		if promo.Get < 1 {
+			sl.ReportError(promo.Get, "get", "Get", "min", "1")
+		}
+	}
+	if promo.StartsAt != nil && promo.EndsAt != nil && !promo.EndsAt.After(*promo.StartsAt) {
+		sl.ReportError(promo.EndsAt, "ends_at", "EndsAt", "gtfield", "StartsAt")
+	}
+}
+
+//activeFilter matches the promotions running at t
+func activeFilter(t time.Time) bson.M {
+	return bson.M{"$and": bson.A{
+		bson.M{"$or": bson.A{bson.M{"starts_at": nil}, bson.M{"starts_at": bson.M{"$lte": t}}}},
+		bson.M{"$or": bson.A{bson.M{"ends_at": nil}, bson.M{"ends_at": bson.M{"$gt": t}}}},
+	}}
+}
+
+//targets reports whether promo applies to product
+func (promo Promotion) targets(product Product) bool {
+	if promo.Kind == promoFixed && promo.Currency != product.Currency {
+		return false
+	}
+	if len(promo.Vendors) == 0 && len(promo.ProductIDs) == 0 {
+		return true
+	}
+	for _, vendor := range promo.Vendors {
+		if vendor == product.Vendor {
+			return true
+		}
+	}
+	for _, id := range promo.ProductIDs {
+		if id == product.ID {
+			return true
+		}
+	}
+	return false
+}
+
+//apply is price once promo is taken off, never below zero
+func (promo Promotion) apply(price *big.Rat) *big.Rat {
+	result := new(big.Rat)
+	switch promo.Kind {
+	case promoPercentage:
+		result.Mul(price, big.NewRat(int64(100-promo.Percent), 100))
+	case promoBuyXGetY:
+		result.Mul(price, big.NewRat(int64(promo.Buy), int64(promo.Buy+promo.Get)))
+	case promoFixed:
+		result.Sub(price, promo.Amount.rat())
+	default:
+		result.Set(price)
+	}
+	if result.Sign() < 0 {
+		result.SetInt64(0)
+	}
+	return result
+}
+
+//effectivePrice is the price of product once its discount and the best combination of
+//promos are taken off, rounded to the minor unit of its currency with halves away from
+//zero. It also returns the promotions used.
+func effectivePrice(product Product, promos []Promotion) (Money, []primitive.ObjectID) {
+	exp, ok := currencyExponent(product.Currency)
+	if !ok {
+		exp = 2
+	}
+	base := new(big.Rat).Mul(product.Price.rat(), big.NewRat(int64(100-product.Discount), 100))
+	var stackable, exclusive []Promotion
+	for _, promo := range promos {
+		if !promo.targets(product) {
+			continue
+		}
+		if promo.Stackable {
+			stackable = append(stackable, promo)
+		} else {
+			exclusive = append(exclusive, promo)
+		}
+	}
+	sort.SliceStable(stackable, func(i, j int) bool {
+		return promoOrder[stackable[i].Kind] < promoOrder[stackable[j].Kind]
+	})
+	best, applied := base, []primitive.ObjectID{}
+	for _, promo := range stackable {
+		best = promo.apply(best)
+		applied = append(applied, promo.ID)
+	}
+	for _, promo := range exclusive {
+		if price := promo.apply(base); price.Cmp(best) < 0 {
+			best, applied = price, []primitive.ObjectID{promo.ID}
+		}
+	}
+	return roundMoney(best, exp), applied
+}
+
+func findPromotions(ctx context.Context, filter bson.M, collection dbiface.CollectionAPI) ([]Promotion, *echo.HTTPError) {
+	promos := []Promotion{}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		log.Errorf("Unable to find the promotions : %v", err)
+		return promos,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the promotions"})
+	}
+	if err = cursor.All(ctx, &promos); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return promos,
+			echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse retrieved promotions"})
+	}
+	return promos, nil
+}
+
+//applyPromotions sets the effective price of products from the promotions running now
+func (h *ProductHandler) applyPromotions(products ...*Product) *echo.HTTPError {
+	if len(products) == 0 {
+		return nil
+	}
+	promos, httpError := findPromotions(context.Background(), activeFilter(now()), h.PromoCol)
+	if httpError != nil {
+		return httpError
+	}
+	for _, product := range products {
+		price, applied := effectivePrice(*product, promos)
+		product.EffectivePrice = &price
+		if len(applied) > 0 {
+			product.Promotions = applied
+		}
+	}
+	return nil
+}
+
+//priceProducts computes the prices of product responses: the effective price, then the
+//conversion into the currency asked for, if any
+func (h *ProductHandler) priceProducts(c echo.Context, products ...*Product) *echo.HTTPError {
+	if httpError := h.applyPromotions(products...); httpError != nil {
+		return httpError
+	}
+	return h.convertPrices(c, products...)
+}
+
+func decodePromotion(c echo.Context) (Promotion, *echo.HTTPError) {
+	var promo Promotion
+	if err := json.NewDecoder(c.Request().Body).Decode(&promo); err != nil {
+		log.Errorf("Unable to decode the promotion : %v", err)
+		return promo, echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse request payload"})
+	}
+	if err := v.Struct(promo); err != nil {
+		log.Errorf("Unable to validate the promotion %+v %v", promo, err)
+		return promo, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: err.Error()})
+	}
+	return promo, nil
+}
+
+func findPromotion(ctx context.Context, id string, collection dbiface.CollectionAPI) (Promotion, *echo.HTTPError) {
+	var promo Promotion
+	docID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Errorf("Unable to convert to Object ID : %v", err)
+		return promo, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "unable to convert to ObjectID"})
+	}
+	if err := collection.FindOne(ctx, bson.M{"_id": docID}).Decode(&promo); err != nil {
+		log.Errorf("Unable to find the promotion : %v", err)
+		return promo, echo.NewHTTPError(http.StatusNotFound, errorMessage{Message: "unable to find the promotion"})
+	}
+	return promo, nil
+}
+
+//GetPromotions gets the promotions, only the running ones with active=true
+func (ph *PromotionsHandler) GetPromotions(c echo.Context) error {
+	filter := bson.M{}
+	if raw := c.QueryParam("active"); raw != "" {
+		active, err := strconv.ParseBool(raw)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, errorMessage{Message: "active must be true or false"})
+		}
+		if active {
+			filter = activeFilter(now())
+		}
+	}
+	promos, httpError := findPromotions(context.Background(), filter, ph.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	return c.JSON(http.StatusOK, promos)
+}
+
+//GetPromotion gets a single promotion
+func (ph *PromotionsHandler) GetPromotion(c echo.Context) error {
+	promo, httpError := findPromotion(context.Background(), c.Param("id"), ph.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	return c.JSON(http.StatusOK, promo)
+}
+
+//CreatePromotion creates a promotion
+func (ph *PromotionsHandler) CreatePromotion(c echo.Context) error {
+	promo, httpError := decodePromotion(c)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	promo.ID = primitive.NewObjectID()
+	promo.CreatedAt = now()
+	promo.UpdatedAt = promo.CreatedAt
+	_, err := ph.Col.InsertOne(context.Background(), promo)
+	if err == nil {
+		err = touchCatalog(context.Background(), ph.ChangeCol)
+	}
+	if err != nil {
+		log.Errorf("Unable to insert the promotion : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to insert the promotion"})
+	}
+	return c.JSON(http.StatusCreated, promo)
+}
+
+//UpdatePromotion replaces a promotion
+func (ph *PromotionsHandler) UpdatePromotion(c echo.Context) error {
+	previous, httpError := findPromotion(context.Background(), c.Param("id"), ph.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	promo, httpError := decodePromotion(c)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	promo.ID = previous.ID
+	promo.CreatedAt = previous.CreatedAt
+	promo.UpdatedAt = now()
+	update, err := changedFields(previous, promo)
+	if err == nil && len(update) > 0 {
+		_, err = ph.Col.UpdateOne(context.Background(), bson.M{"_id": promo.ID}, update)
+	}
+	if err == nil {
+		err = touchCatalog(context.Background(), ph.ChangeCol)
+	}
+	if err != nil {
+		log.Errorf("Unable to update the promotion : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to update the promotion"})
+	}
+	return c.JSON(http.StatusOK, promo)
+}
+
+//DeletePromotion deletes a promotion
+func (ph *PromotionsHandler) DeletePromotion(c echo.Context) error {
+	docID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		log.Errorf("Unable to convert to Object ID : %v", err)
+		return c.JSON(http.StatusBadRequest, errorMessage{Message: "unable to convert to ObjectID"})
+	}
+	res, err := ph.Col.DeleteOne(context.Background(), bson.M{"_id": docID})
+	if err == nil && res.DeletedCount > 0 {
+		err = touchCatalog(context.Background(), ph.ChangeCol)
+	}
+	if err != nil {
+		log.Errorf("Unable to delete the promotion : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to delete the promotion"})
+	}
+	return c.JSON(http.StatusOK, res.DeletedCount)
+}
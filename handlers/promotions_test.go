@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPromotions(t *testing.T) {
+	var promoID string
+
+	t.Run("effective price", func(t *testing.T) {
+		price, _ := ParseMoney("100.00")
+		five, _ := ParseMoney("5")
+		product := Product{ID: primitive.NewObjectID(), Price: price, Currency: "USD", Vendor: "google", Discount: 10}
+		percent := Promotion{ID: primitive.NewObjectID(), Kind: promoPercentage, Percent: 20, Stackable: true}
+		fixed := Promotion{ID: primitive.NewObjectID(), Kind: promoFixed, Amount: &five, Currency: "USD", Stackable: true}
+		bogo := Promotion{ID: primitive.NewObjectID(), Kind: promoBuyXGetY, Buy: 1, Get: 1, Vendors: []string{"google"}}
+		other := Promotion{ID: primitive.NewObjectID(), Kind: promoPercentage, Percent: 90, Vendors: []string{"apple"}}
+		euro := Promotion{ID: primitive.NewObjectID(), Kind: promoFixed, Amount: &five, Currency: "EUR", Stackable: true}
+
+		for _, tc := range []struct {
+			promos  []Promotion
+			want    string
+			applied []primitive.ObjectID
+		}{
+			{nil, "90.00", []primitive.ObjectID{}},
+			{[]Promotion{fixed, percent}, "67.00", []primitive.ObjectID{percent.ID, fixed.ID}},
+			{[]Promotion{fixed, percent, bogo}, "45.00", []primitive.ObjectID{bogo.ID}},
+			{[]Promotion{other, euro}, "90.00", []primitive.ObjectID{}},
+		} {
+			got, applied := effectivePrice(product, tc.promos)
+			assert.Equal(t, tc.want, got.String())
+			assert.Equal(t, tc.applied, applied)
+		}
+	})
+
+	t.Run("create promotion", func(t *testing.T) {
+		var promo Promotion
+		body := fmt.Sprintf(`{"name":"summer","kind":"percentage","percent":15,"vendors":["google"],"ends_at":%q}`,
+			time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+		req := httptest.NewRequest(http.MethodPost, "/promotions", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		err := ph.CreatePromotion(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &promo)
+		assert.Nil(t, err)
+		promoID = promo.ID.Hex()
+	})
+
+	t.Run("create promotion invalid unhappy", func(t *testing.T) {
+		for _, body := range []string{
+			`{"name":"nopercent","kind":"percentage"}`,
+			`{"name":"noamount","kind":"fixed","currency":"USD"}`,
+			`{"name":"halfyen","kind":"fixed","amount":"0.5","currency":"JPY"}`,
+			`{"name":"nobuy","kind":"buy_x_get_y","get":1}`,
+			`{"name":"unknown","kind":"bundle"}`,
+			`{"name":"backwards","kind":"percentage","percent":5,"starts_at":"2020-02-01T00:00:00Z","ends_at":"2020-01-01T00:00:00Z"}`,
+		} {
+			req := httptest.NewRequest(http.MethodPost, "/promotions", strings.NewReader(body))
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			err := ph.CreatePromotion(c)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusBadRequest, res.Code, body)
+		}
+	})
+
+	t.Run("get active promotions", func(t *testing.T) {
+		var promos []Promotion
+		req := httptest.NewRequest(http.MethodGet, "/promotions?active=true", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		err := ph.GetPromotions(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &promos)
+		assert.Nil(t, err)
+		assert.Len(t, promos, 1)
+	})
+
+	t.Run("get a product with a promotion", func(t *testing.T) {
+		var products []Product
+		req := httptest.NewRequest(http.MethodGet, "/products?vendor=google&product_name=pixel", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.GetProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &products)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, products)
+		for _, product := range products {
+			assert.Equal(t, "595.00", product.EffectivePrice.String())
+			assert.Equal(t, promoID, product.Promotions[0].Hex())
+		}
+	})
+
+	t.Run("a new promotion changes the etag of a product", func(t *testing.T) {
+		var products []Product
+		req := httptest.NewRequest(http.MethodGet, "/products?vendor=google&product_name=pixel", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.GetProducts(c)
+		assert.Nil(t, err)
+		err = json.Unmarshal(res.Body.Bytes(), &products)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, products)
+		id := products[0].ID.Hex()
+		getProduct := func(etag string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/products/"+id, nil)
+			req.Header.Set("If-None-Match", etag)
+			res := httptest.NewRecorder()
+			c := e.NewContext(req, res)
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+			err := h.GetProduct(c)
+			assert.Nil(t, err)
+			return res
+		}
+		res = getProduct("")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Empty(t, res.Header().Get("Last-Modified"))
+		etag := res.Header().Get("ETag")
+		assert.Equal(t, http.StatusNotModified, getProduct(etag).Code)
+
+		var flash Promotion
+		body := fmt.Sprintf(`{"name":"flash","kind":"percentage","percent":50,"product_ids":[%q]}`, id)
+		req = httptest.NewRequest(http.MethodPost, "/promotions", strings.NewReader(body))
+		res = httptest.NewRecorder()
+		c = e.NewContext(req, res)
+		err = ph.CreatePromotion(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &flash)
+		assert.Nil(t, err)
+
+		res = getProduct(etag)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.NotEqual(t, etag, res.Header().Get("ETag"))
+		version := res.Header().Get("X-Version")
+
+		body = `{"product_name":"pixel","price":"700","currency":"USD","vendor":"google"}`
+		req = httptest.NewRequest(http.MethodPut, "/products/"+id, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("If-Match", version)
+		res = httptest.NewRecorder()
+		c = e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		err = h.UpdateProduct(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		req = httptest.NewRequest(http.MethodDelete, "/promotions/"+flash.ID.Hex(), nil)
+		res = httptest.NewRecorder()
+		c = e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(flash.ID.Hex())
+		err = ph.DeletePromotion(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+	})
+
+	t.Run("update promotion", func(t *testing.T) {
+		var promo Promotion
+		body := `{"name":"summer","kind":"fixed","amount":"50","currency":"USD","stackable":true}`
+		req := httptest.NewRequest(http.MethodPut, "/promotions/"+promoID, strings.NewReader(body))
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(promoID)
+		err := ph.UpdatePromotion(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &promo)
+		assert.Nil(t, err)
+		assert.Equal(t, "50", promo.Amount.String())
+		assert.Empty(t, promo.Vendors)
+	})
+
+	t.Run("delete promotion", func(t *testing.T) {
+		var delCount int64
+		req := httptest.NewRequest(http.MethodDelete, "/promotions/"+promoID, nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(promoID)
+		err := ph.DeletePromotion(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &delCount)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), delCount)
+		changed, err := latestTime(context.Background(), bson.M{"_id": catalogChangeID}, "changed_at", changeCol)
+		assert.Nil(t, err)
+		assert.False(t, changed.IsZero())
+	})
+}
@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	//maxFilters caps the number of filter keys in a single query
+	maxFilters = 20
+	//maxFilterValues caps the number of comma separated values of in, nin and all
+	maxFilterValues = 100
+	//maxFilterValueLen caps the length in bytes of a single filter value
+	maxFilterValueLen = 256
+)
+
+var (
+	//filterKey matches a query key such as price or price[gte]
+	filterKey = regexp.MustCompile(`^([^\[\]]+)(?:\[([a-z]+)\])?$`)
+
+	//filterOps maps the operators accepted in the query string to mongo operators
+	filterOps = map[string]string{
+		"eq":     "$eq",
+		"ne":     "$ne",
+		"gt":     "$gt",
+		"gte":    "$gte",
+		"lt":     "$lt",
+		"lte":    "$lte",
+		"in":     "$in",
+		"nin":    "$nin",
+		"all":    "$all",
+		"exists": "$exists",
+	}
+
+	//anyValueOps are the operators eq and ne become on a value of any type
+	anyValueOps = map[string]string{"$eq": "$in", "$ne": "$nin"}
+
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	timeType     = reflect.TypeOf(time.Time{})
+
+	productFields = filterableProductFields()
+	//productSortFields also sorts the trash by deletion time
+	productSortFields = sortableProductFields()
+)
+
+//filterableProductFields are the product fields clients may filter and sort on, the trash is
+//only reachable through its own endpoint
+func filterableProductFields() map[string]reflect.Type {
+	fields := bsonFields(reflect.TypeOf(Product{}))
+	delete(fields, "deleted_at")
+	delete(fields, "deleted_by")
+	for name, t := range fields {
+		if !filterable(t) {
+			delete(fields, name)
+		}
+	}
+	return fields
+}
+
+func sortableProductFields() map[string]reflect.Type {
+	fields := filterableProductFields()
+	fields["deleted_at"] = timeType
+	return fields
+}
+
+//filterable reports whether convertValue can convert a query string value to t, or to the
+//values of t when it is a map of any values such as specs
+func filterable(t reflect.Type) bool {
+	if t == objectIDType || t == moneyType || t == timeType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return filterable(t.Elem())
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.Interface
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+//filterError is returned when a query filters on a field which is not allowed
+type filterError struct {
+	Message       string   `json:"message"`
+	AllowedFields []string `json:"allowed_fields"`
+}
+
+func allowedFields(fields map[string]reflect.Type) []string {
+	names := make([]string, 0, len(fields))
+	for name, t := range fields {
+		if t.Kind() == reflect.Map {
+			name += ".<attribute>"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//bsonFields maps the bson names of the fields of a struct to their types
+func bsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("bson"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+//fieldType is the type of the field name in fields. A key of a map of any values, such as
+//specs.ram, has the type of the values of the map, the map itself cannot be filtered on.
+func fieldType(fields map[string]reflect.Type, name string) reflect.Type {
+	if t := fields[name]; t != nil {
+		if t.Kind() == reflect.Map {
+			return nil
+		}
+		return t
+	}
+	i := strings.Index(name, ".")
+	if i < 0 || !attributePattern.MatchString(name[i+1:]) {
+		return nil
+	}
+	t := fields[name[:i]]
+	if t == nil || t.Kind() != reflect.Map || t.Elem().Kind() != reflect.Interface {
+		return nil
+	}
+	return t.Elem()
+}
+
+//inferValue converts a raw query string value compared to a value of any type, a boolean or
+//a number is taken as such and anything else as a string
+func inferValue(raw string) interface{} {
+	if raw == "true" || raw == "false" {
+		return raw == "true"
+	}
+	if n, err := strconv.ParseFloat(raw, 64); err == nil {
+		return n
+	}
+	return raw
+}
+
+//convertValue converts a raw query string value to the type of the field it is compared to
+func convertValue(raw string, t reflect.Type) (interface{}, error) {
+	if len(raw) > maxFilterValueLen {
+		return nil, fmt.Errorf("value longer than %d bytes", maxFilterValueLen)
+	}
+	if t == objectIDType {
+		return primitive.ObjectIDFromHex(raw)
+	}
+	if t == moneyType {
+		return ParseMoney(raw)
+	}
+	if t == timeType {
+		return time.Parse(time.RFC3339, raw)
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return convertValue(raw, t.Elem())
+	case reflect.String:
+		return raw, nil
+	case reflect.Bool:
+		return strconv.ParseBool(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(raw, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(raw, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(raw, 64)
+	case reflect.Interface:
+		return inferValue(raw), nil
+	}
+	return nil, fmt.Errorf("unsupported field type %s", t)
+}
+
+func convertOperand(op, raw string, t reflect.Type) (interface{}, error) {
+	switch op {
+	case "$exists":
+		return strconv.ParseBool(raw)
+	case "$in", "$nin", "$all":
+		items := strings.Split(raw, ",")
+		if len(items) > maxFilterValues {
+			return nil, fmt.Errorf("more than %d values", maxFilterValues)
+		}
+		values := bson.A{}
+		for _, item := range items {
+			val, err := convertValue(item, t)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, val)
+			if _, ok := val.(string); op != "$all" && t.Kind() == reflect.Interface && !ok {
+				values = append(values, item)
+			}
+		}
+		return values, nil
+	}
+	return convertValue(raw, t)
+}
+
+//checkSort fails with 400 Bad Request unless every key of sort is one of fields
+func checkSort(sort bson.D, fields map[string]reflect.Type) *echo.HTTPError {
+	for _, e := range sort {
+		if fieldType(fields, e.Key) == nil {
+			log.Errorf("Invalid sort key : %s", e.Key)
+			return echo.NewHTTPError(http.StatusBadRequest, filterError{
+				Message:       fmt.Sprintf("invalid sort key %s", e.Key),
+				AllowedFields: allowedFields(fields),
+			})
+		}
+	}
+	return nil
+}
+
+//parseFilter turns a query string such as price[gte]=100&vendor[in]=apple,google
+//into a mongo filter, converting every value to the type of the field in fields.
+//Only the keys of fields can be filtered on, anything else such as $where is rejected.
+//The keys of a map of any values such as specs are filtered on as specs.ram[gte]=8.
+func parseFilter(q url.Values, fields map[string]reflect.Type) (bson.M, *echo.HTTPError) {
+	conds := make(map[string]bson.M)
+	//keys are the query keys of the operators already set on each field
+	keys := make(map[string]string)
+	count := 0
+	for k, vals := range q {
+		if pageParams[k] {
+			continue
+		}
+		if count++; count > maxFilters {
+			log.Errorf("Too many filters in query : %v", q)
+			return nil, echo.NewHTTPError(http.StatusBadRequest,
+				errorMessage{Message: fmt.Sprintf("at most %d filters are allowed", maxFilters)})
+		}
+		m := filterKey.FindStringSubmatch(k)
+		if m == nil || strings.HasPrefix(m[1], "$") || fieldType(fields, m[1]) == nil {
+			log.Errorf("Invalid filter key : %s", k)
+			return nil, echo.NewHTTPError(http.StatusBadRequest, filterError{
+				Message:       fmt.Sprintf("invalid filter %s", k),
+				AllowedFields: allowedFields(fields),
+			})
+		}
+		if len(vals) > 1 {
+			return nil, echo.NewHTTPError(http.StatusBadRequest,
+				errorMessage{Message: fmt.Sprintf("filter %s can only be given once", k)})
+		}
+		field, op := m[1], "$eq"
+		if m[2] != "" {
+			var ok bool
+			if op, ok = filterOps[m[2]]; !ok {
+				log.Errorf("Invalid filter operator : %s", k)
+				return nil, echo.NewHTTPError(http.StatusBadRequest,
+					errorMessage{Message: fmt.Sprintf("unknown operator %s in %s", m[2], k)})
+			}
+		}
+		t := fieldType(fields, field)
+		val, err := convertOperand(op, vals[0], t)
+		if err != nil {
+			log.Errorf("Invalid value for %s : %v", k, err)
+			return nil, echo.NewHTTPError(http.StatusBadRequest,
+				errorMessage{Message: fmt.Sprintf("invalid value for %s", k)})
+		}
+		//eq and ne on a number or a boolean of any type also match its string form
+		if anyOp, ok := anyValueOps[op]; ok && t.Kind() == reflect.Interface {
+			if _, isString := val.(string); !isString {
+				op, val = anyOp, bson.A{val, vals[0]}
+			}
+		}
+		if conds[field] == nil {
+			conds[field] = bson.M{}
+		}
+		//price=5 and price[eq]=6, or specs.ram=8 and specs.ram[in]=4,16, end up as one operator
+		if prev, ok := keys[field+op]; ok {
+			pair := []string{prev, k}
+			sort.Strings(pair)
+			return nil, echo.NewHTTPError(http.StatusBadRequest,
+				errorMessage{Message: fmt.Sprintf("filters %s and %s cannot be combined", pair[0], pair[1])})
+		}
+		keys[field+op] = k
+		conds[field][op] = val
+	}
+	filter := bson.M{}
+	for field, cond := range conds {
+		if eq, ok := cond["$eq"]; ok && len(cond) == 1 {
+			filter[field] = eq
+			continue
+		}
+		filter[field] = cond
+	}
+	return filter, nil
+}
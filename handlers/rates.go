@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//displayCurrencyParam is the query parameter of product reads asking for converted prices,
+//filter on the stored currency with currency[eq]= instead
+const displayCurrencyParam = "currency"
+
+//ExchangeRate is the number of units of Currency worth one unit of the base currency
+type ExchangeRate struct {
+	Currency  string    `json:"currency" bson:"_id" validate:"required,len=3,alpha,uppercase"`
+	Rate      float64   `json:"rate" bson:"rate" validate:"required,gt=0"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+//ConvertedPrice is the price of a product in the currency asked for with ?currency=.
+//The converted price is rounded to the minor unit of the currency (its ISO 4217 exponent,
+//2 for unknown currencies) with halves rounded away from zero. Rate is the unrounded rate
+//applied and RatesAsOf the time of the oldest exchange rate it was derived from.
+type ConvertedPrice struct {
+	Currency       string     `json:"currency"`
+	Price          Money      `json:"price"`
+	EffectivePrice *Money     `json:"effective_price,omitempty"`
+	Rate           float64    `json:"rate"`
+	RatesAsOf      *time.Time `json:"rates_as_of,omitempty"`
+}
+
+//RatesHandler an exchange rates handler
+type RatesHandler struct {
+	Col  dbiface.CollectionAPI
+	Base string
+}
+
+//exchangeTable converts prices into a single currency
+type exchangeTable struct {
+	base   string
+	target string
+	rates  map[string]ExchangeRate
+}
+
+func findRates(ctx context.Context, collection dbiface.CollectionAPI) ([]ExchangeRate, error) {
+	rates := []ExchangeRate{}
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return rates, err
+	}
+	err = cursor.All(ctx, &rates)
+	return rates, err
+}
+
+//findExchangeTable loads the rates needed to convert prices into currency
+func findExchangeTable(ctx context.Context, currency, base string, collection dbiface.CollectionAPI) (exchangeTable, *echo.HTTPError) {
+	table := exchangeTable{base: base, target: currency, rates: make(map[string]ExchangeRate)}
+	rates, err := findRates(ctx, collection)
+	if err != nil {
+		log.Errorf("Unable to find the exchange rates : %v", err)
+		return table,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the exchange rates"})
+	}
+	for _, rate := range rates {
+		table.rates[rate.Currency] = rate
+	}
+	if _, ok := table.rate(currency); !ok {
+		return table,
+			echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: fmt.Sprintf("no exchange rate for %s", currency)})
+	}
+	return table, nil
+}
+
+//rate is the exchange rate of currency, the base currency is always worth 1
+func (t exchangeTable) rate(currency string) (ExchangeRate, bool) {
+	if currency == t.base {
+		return ExchangeRate{Currency: currency, Rate: 1}, true
+	}
+	rate, ok := t.rates[currency]
+	return rate, ok
+}
+
+//convert sets the converted price of product
+func (t exchangeTable) convert(product *Product) *echo.HTTPError {
+	from, ok := t.rate(product.Currency)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity,
+			errorMessage{Message: fmt.Sprintf("no exchange rate for %s", product.Currency)})
+	}
+	to, _ := t.rate(t.target)
+	converted := ConvertedPrice{Currency: t.target, Price: product.Price, EffectivePrice: product.EffectivePrice, Rate: 1}
+	if from.Currency != to.Currency {
+		converted.Rate = to.Rate / from.Rate
+		exp, ok := currencyExponent(t.target)
+		if !ok {
+			exp = 2
+		}
+		converted.Price = product.Price.convert(converted.Rate, exp)
+		if product.EffectivePrice != nil {
+			effective := product.EffectivePrice.convert(converted.Rate, exp)
+			converted.EffectivePrice = &effective
+		}
+		asOf := from.UpdatedAt
+		if asOf.IsZero() || (!to.UpdatedAt.IsZero() && to.UpdatedAt.Before(asOf)) {
+			asOf = to.UpdatedAt
+		}
+		if !asOf.IsZero() {
+			converted.RatesAsOf = &asOf
+		}
+	}
+	product.Converted = &converted
+	return nil
+}
+
+func productRefs(products []Product) []*Product {
+	refs := make([]*Product, len(products))
+	for i := range products {
+		refs[i] = &products[i]
+	}
+	return refs
+}
+
+//convertPrices converts the prices of products into the currency asked for with ?currency=,
+//if any
+func (h *ProductHandler) convertPrices(c echo.Context, products ...*Product) *echo.HTTPError {
+	currency := c.QueryParam(displayCurrencyParam)
+	if currency == "" {
+		return nil
+	}
+	table, httpError := findExchangeTable(context.Background(), strings.ToUpper(currency), h.BaseCurrency, h.RateCol)
+	if httpError != nil {
+		return httpError
+	}
+	for _, product := range products {
+		if httpError := table.convert(product); httpError != nil {
+			return httpError
+		}
+	}
+	return nil
+}
+
+func validateRates(rates []ExchangeRate, base string) error {
+	for _, rate := range rates {
+		if err := v.Struct(rate); err != nil {
+			return fmt.Errorf("invalid rate for %q : %v", rate.Currency, err)
+		}
+		if rate.Currency == base {
+			return fmt.Errorf("the base currency %s always has rate 1", base)
+		}
+	}
+	return nil
+}
+
+func saveRates(ctx context.Context, rates []ExchangeRate, collection dbiface.CollectionAPI) error {
+	for _, rate := range rates {
+		if rate.UpdatedAt.IsZero() {
+			rate.UpdatedAt = now()
+		}
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": rate.Currency},
+			bson.M{"$set": bson.M{"rate": rate.Rate, "updated_at": rate.UpdatedAt}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//parseRates reads rates from a JSON array of rates or from CSV rows of currency,rate[,updated_at]
+//with an optional header row
+func parseRates(r io.Reader, format string) ([]ExchangeRate, error) {
+	var rates []ExchangeRate
+	if format != "csv" {
+		err := json.NewDecoder(r).Decode(&rates)
+		return rates, err
+	}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for i, record := range records {
+		if i == 0 && strings.EqualFold(record[0], "currency") {
+			continue
+		}
+		if len(record) < 2 || len(record) > 3 {
+			return nil, fmt.Errorf("line %d: expected currency,rate[,updated_at]", i+1)
+		}
+		rate := ExchangeRate{Currency: record[0]}
+		if rate.Rate, err = strconv.ParseFloat(record[1], 64); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if len(record) == 3 && record[2] != "" {
+			if rate.UpdatedAt, err = time.Parse(time.RFC3339, record[2]); err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+//LoadRates stores the exchange rates of a local .json or .csv file, see parseRates for the format
+func LoadRates(ctx context.Context, path, base string, collection dbiface.CollectionAPI) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	rates, err := parseRates(f, strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."))
+	if err != nil {
+		return 0, err
+	}
+	if err := validateRates(rates, base); err != nil {
+		return 0, err
+	}
+	return len(rates), saveRates(ctx, rates, collection)
+}
+
+//GetRates gets the exchange rates, each relative to the base currency
+func (rh *RatesHandler) GetRates(c echo.Context) error {
+	rates, err := findRates(context.Background(), rh.Col)
+	if err != nil {
+		log.Errorf("Unable to find the exchange rates : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to find the exchange rates"})
+	}
+	return c.JSON(http.StatusOK, rates)
+}
+
+//PutRate creates or replaces the exchange rate of a currency
+func (rh *RatesHandler) PutRate(c echo.Context) error {
+	var rate ExchangeRate
+	if err := json.NewDecoder(c.Request().Body).Decode(&rate); err != nil {
+		log.Errorf("Unable to decode the rate : %v", err)
+		return c.JSON(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse request payload"})
+	}
+	rate.Currency = strings.ToUpper(c.Param("currency"))
+	rate.UpdatedAt = now()
+	if err := validateRates([]ExchangeRate{rate}, rh.Base); err != nil {
+		log.Errorf("Unable to validate the rate : %v", err)
+		return c.JSON(http.StatusBadRequest, errorMessage{Message: err.Error()})
+	}
+	if err := saveRates(context.Background(), []ExchangeRate{rate}, rh.Col); err != nil {
+		log.Errorf("Unable to save the rate : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to save the rate"})
+	}
+	return c.JSON(http.StatusOK, rate)
+}
+
+//DeleteRate removes the exchange rate of a currency
+func (rh *RatesHandler) DeleteRate(c echo.Context) error {
+	res, err := rh.Col.DeleteOne(context.Background(), bson.M{"_id": strings.ToUpper(c.Param("currency"))})
+	if err != nil {
+		log.Errorf("Unable to delete the rate : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to delete the rate"})
+	}
+	return c.JSON(http.StatusOK, res.DeletedCount)
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRates(t *testing.T) {
+
+	t.Run("parse csv rates", func(t *testing.T) {
+		csv := "currency,rate,updated_at\nEUR,0.92,2020-05-01T00:00:00Z\nGBP, 0.8\n"
+		rates, err := parseRates(strings.NewReader(csv), "csv")
+		assert.Nil(t, err)
+		assert.Len(t, rates, 2)
+		assert.Equal(t, "EUR", rates[0].Currency)
+		assert.Equal(t, 0.92, rates[0].Rate)
+		assert.Equal(t, 2020, rates[0].UpdatedAt.Year())
+		assert.True(t, rates[1].UpdatedAt.IsZero())
+	})
+
+	t.Run("put rate", func(t *testing.T) {
+		var rate ExchangeRate
+		req := httptest.NewRequest(http.MethodPut, "/rates/eur", strings.NewReader(`{"rate":0.92}`))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("currency")
+		c.SetParamValues("eur")
+		err := rh.PutRate(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &rate)
+		assert.Nil(t, err)
+		assert.Equal(t, "EUR", rate.Currency)
+	})
+
+	t.Run("put rate invalid unhappy", func(t *testing.T) {
+		for currency, body := range map[string]string{"GBP": `{"rate":-1}`, "E1R": `{"rate":1}`, rh.Base: `{"rate":2}`} {
+			req := httptest.NewRequest(http.MethodPut, "/rates/"+currency, strings.NewReader(body))
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			c.SetParamNames("currency")
+			c.SetParamValues(currency)
+			err := rh.PutRate(c)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusBadRequest, res.Code)
+		}
+	})
+
+	t.Run("get rates", func(t *testing.T) {
+		var rates []ExchangeRate
+		req := httptest.NewRequest(http.MethodGet, "/rates", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		err := rh.GetRates(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &rates)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, rates)
+	})
+
+	t.Run("delete rate", func(t *testing.T) {
+		var delCount int64
+		req := httptest.NewRequest(http.MethodDelete, "/rates/EUR", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("currency")
+		c.SetParamValues("EUR")
+		err := rh.DeleteRate(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &delCount)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), delCount)
+	})
+}
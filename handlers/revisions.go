@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	correlationIDHeader = "X-Correlation-ID"
+
+	revisionCreate  = "create"
+	revisionUpdate  = "update"
+	revisionDelete  = "delete"
+	revisionRestore = "restore"
+	revisionRevert  = "revert"
+
+	//revisionAttempts is the number of times a revision write is tried
+	revisionAttempts = 3
+)
+
+//fieldChange is the value of a product field before and after a revision
+type fieldChange struct {
+	From interface{} `json:"from" bson:"from"`
+	To   interface{} `json:"to" bson:"to"`
+}
+
+//MarshalJSON writes the Decimal128 values of c, such as prices, as strings
+func (c fieldChange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		From interface{} `json:"from"`
+		To   interface{} `json:"to"`
+	}{jsonValue(c.From), jsonValue(c.To)})
+}
+
+//Revision is an immutable record of a change to a product.
+//Rev is the version of the product the change produced.
+type Revision struct {
+	ID            primitive.ObjectID     `json:"_id" bson:"_id"`
+	ProductID     primitive.ObjectID     `json:"product_id" bson:"product_id"`
+	Rev           int64                  `json:"rev" bson:"rev"`
+	Op            string                 `json:"op" bson:"op"`
+	Diff          map[string]fieldChange `json:"diff" bson:"diff"`
+	Snapshot      Product                `json:"snapshot" bson:"snapshot"`
+	Actor         string                 `json:"actor" bson:"actor"`
+	CorrelationID string                 `json:"correlation_id" bson:"correlation_id"`
+	CreatedAt     time.Time              `json:"created_at" bson:"created_at"`
+}
+
+//bsonDoc is the document v is stored as
+func bsonDoc(v interface{}) (bson.M, error) {
+	var doc bson.M
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	err = bson.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+//diffProducts lists the stored fields which differ between before and after, before is nil on creation
+func diffProducts(before *Product, after Product) (map[string]fieldChange, error) {
+	prev := bson.M{}
+	if before != nil {
+		var err error
+		if prev, err = bsonDoc(before); err != nil {
+			return nil, err
+		}
+	}
+	next, err := bsonDoc(after)
+	if err != nil {
+		return nil, err
+	}
+	diff := make(map[string]fieldChange)
+	for k, v := range next {
+		if !reflect.DeepEqual(prev[k], v) {
+			diff[k] = fieldChange{From: prev[k], To: v}
+		}
+	}
+	for k, v := range prev {
+		if _, ok := next[k]; !ok {
+			diff[k] = fieldChange{From: v}
+		}
+	}
+	return diff, nil
+}
+
+//newRevision describes the change from before to after made by the request c
+func newRevision(c echo.Context, op string, before *Product, after Product) (Revision, error) {
+	diff, err := diffProducts(before, after)
+	if err != nil {
+		return Revision{}, err
+	}
+	return Revision{
+		ID:            primitive.NewObjectID(),
+		ProductID:     after.ID,
+		Rev:           after.Version,
+		Op:            op,
+		Diff:          diff,
+		Snapshot:      after,
+		Actor:         actor(c),
+		CorrelationID: c.Request().Header.Get(correlationIDHeader),
+		CreatedAt:     now(),
+	}, nil
+}
+
+//insertRevision writes revision, trying up to revisionAttempts times. A retry colliding with
+//the revision itself finds the write of an attempt whose answer was lost.
+func insertRevision(ctx context.Context, revision Revision, collection dbiface.CollectionAPI) error {
+	var err error
+	for attempt := 1; attempt <= revisionAttempts; attempt++ {
+		if _, err = collection.InsertOne(ctx, revision); err == nil {
+			return nil
+		}
+		if isDuplicateKey(err) {
+			if attempt > 1 {
+				if n, countErr := collection.CountDocuments(ctx, bson.M{"_id": revision.ID}); countErr == nil && n > 0 {
+					return nil
+				}
+			}
+			return err
+		}
+	}
+	return err
+}
+
+//recordRevision writes the revision of a change which has already been applied. The change
+//cannot be taken back, so a revision which cannot be written fails the request with 500
+//Internal Server Error rather than leave a gap in the history revertProduct works from.
+func recordRevision(c echo.Context, op string, before *Product, after Product, collection dbiface.CollectionAPI) *echo.HTTPError {
+	revision, err := newRevision(c, op, before, after)
+	if err == nil {
+		err = insertRevision(context.Background(), revision, collection)
+	}
+	if err != nil {
+		log.Errorf("Unable to record the %s revision of product %s : %v", op, after.ID.Hex(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to record the revision"})
+	}
+	return nil
+}
+
+func findRevisions(ctx context.Context, productID string, p pagination, collection dbiface.CollectionAPI) ([]Revision, int64, *echo.HTTPError) {
+	revisions := []Revision{}
+	docID, err := primitive.ObjectIDFromHex(productID)
+	if err != nil {
+		log.Errorf("Unable to convert to Object ID : %v", err)
+		return revisions, 0,
+			echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "unable to convert to ObjectID"})
+	}
+	filter := bson.M{"product_id": docID}
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Errorf("Unable to count the revisions : %v", err)
+		return revisions, 0,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to count the revisions"})
+	}
+	p.Sort = bson.D{{Key: "rev", Value: -1}}
+	cursor, err := collection.Find(ctx, filter, p.findOptions())
+	if err != nil {
+		log.Errorf("Unable to find the revisions : %v", err)
+		return revisions, 0,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the revisions"})
+	}
+	if err = cursor.All(ctx, &revisions); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return revisions, 0,
+			echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse retrieved revisions"})
+	}
+	return revisions, total, nil
+}
+
+//GetProductHistory gets the revisions of a product, latest first, paged like GetProducts
+func (h *ProductHandler) GetProductHistory(c echo.Context) error {
+	p, httpError := parsePagination(c.QueryParams())
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if p.keyset {
+		return c.JSON(http.StatusBadRequest, errorMessage{Message: "history does not support cursor paging"})
+	}
+	revisions, total, httpError := findRevisions(context.Background(), c.Param("id"), p, h.RevCol)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	setPageHeaders(c, p, total)
+	return c.JSON(http.StatusOK, revisions)
+}
+
+//revertProduct brings the product back to the snapshot of revision rev, as a new revision.
+//Images are not part of the revert, they are only changed through the images endpoints.
+//The references of the snapshot are checked like those of an update since they may be gone.
+func revertProduct(ctx context.Context, id, rev, ifMatch string, collection, revCollection, vendorCol, categoryCol dbiface.CollectionAPI) (Product, Product, *echo.HTTPError) {
+	var revision Revision
+	previous, httpError := findProduct(ctx, id, collection)
+	if httpError != nil {
+		return previous, previous, httpError
+	}
+	if httpError := checkIfMatch(ifMatch, previous.Version); httpError != nil {
+		return previous, previous, httpError
+	}
+	revNumber, err := strconv.ParseInt(rev, 10, 64)
+	if err != nil {
+		return previous, previous, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "rev must be an integer"})
+	}
+	res := revCollection.FindOne(ctx, bson.M{"product_id": previous.ID, "rev": revNumber})
+	if err := res.Decode(&revision); err != nil {
+		log.Errorf("Unable to find the revision : %v", err)
+		return previous, previous,
+			echo.NewHTTPError(http.StatusNotFound, errorMessage{Message: "unable to find the revision"})
+	}
+	product := revision.Snapshot
+	product.ID = previous.ID
+	product.Version = previous.Version
+	product.CreatedAt = previous.CreatedAt
+	product.UpdatedAt = previous.UpdatedAt
+	product.DeletedAt, product.DeletedBy = nil, ""
+	product.Images = previous.Images
+	if err := v.Struct(product); err != nil {
+		log.Errorf("unable to validate the struct : %v", err)
+		return product, previous,
+			echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "the revision is no longer a valid product"})
+	}
+	if httpError := checkReferences(ctx, &product, previous, collection, vendorCol, categoryCol); httpError != nil {
+		return product, previous, httpError
+	}
+	update, err := changedFields(previous, product)
+	if err != nil {
+		log.Errorf("Unable to compare the products : %v", err)
+		return product, previous,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to revert the product"})
+	}
+	if len(update) == 0 {
+		return product, previous, nil
+	}
+	product.Version++
+	product.UpdatedAt = now()
+	update["$inc"] = bson.M{"version": 1}
+	if update["$set"] == nil {
+		update["$set"] = bson.M{}
+	}
+	update["$set"].(bson.M)["updated_at"] = product.UpdatedAt
+	result, err := collection.UpdateOne(ctx, versionFilter(product.ID, previous.Version), update)
+	if isDuplicateKey(err) {
+		return product, previous, echo.NewHTTPError(http.StatusConflict, errorMessage{Message: "a variant SKU is already taken"})
+	}
+	if err != nil {
+		log.Errorf("Unable to revert the product : %v", err)
+		return product, previous,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to revert the product"})
+	}
+	if result.MatchedCount == 0 {
+		return product, previous, versionConflict(ifMatch)
+	}
+	return product, previous, nil
+}
+
+//RevertProduct restores the fields of a product as they were at revision :rev, honouring If-Match
+func (h *ProductHandler) RevertProduct(c echo.Context) error {
+	product, previous, httpError := revertProduct(context.Background(), c.Param("id"), c.Param("rev"),
+		c.Request().Header.Get(headerIfMatch), h.Col, h.RevCol, h.VendorCol, h.CategoryCol)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if product.Version != previous.Version {
+		if httpError := recordRevision(c, revisionRevert, &previous, product, h.RevCol); httpError != nil {
+			return c.JSON(httpError.Code, httpError.Message)
+		}
+		refreshSuggestions(context.Background(), previous, product, h.SuggestCol)
+	}
+	c.Response().Header().Set(headerETag, productETag(product.Version))
+	return c.JSON(http.StatusOK, product)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+//productHit is a product matched by a full text search together with its relevance
+type productHit struct {
+	Product `bson:",inline"`
+	Score   float64 `json:"score" bson:"score"`
+}
+
+//withoutKeys returns a copy of q without the given keys
+func withoutKeys(q url.Values, keys ...string) url.Values {
+	rest := make(url.Values, len(q))
+	for k, v := range q {
+		rest[k] = v
+	}
+	for _, k := range keys {
+		rest.Del(k)
+	}
+	return rest
+}
+
+func searchProducts(ctx context.Context, text string, q url.Values, p pagination, collection dbiface.CollectionAPI) ([]productHit, int64, *echo.HTTPError) {
+	var hits []productHit
+	filter, httpError := productFilter(q)
+	if httpError != nil {
+		return hits, 0, httpError
+	}
+	filter["$text"] = bson.M{"$search": text}
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Errorf("Unable to count the products : %v", err)
+		return hits, 0,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to count the products"})
+	}
+	score := bson.M{"$meta": "textScore"}
+	opts := p.findOptions().
+		SetProjection(bson.M{"score": score}).
+		SetSort(append(bson.D{{Key: "score", Value: score}}, p.Sort...))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Errorf("Unable to search the products : %v", err)
+		return hits, 0,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to search the products"})
+	}
+	err = cursor.All(ctx, &hits)
+	if err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return hits, 0,
+			echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse retrieved products"})
+	}
+	return hits, total, nil
+}
+
+//SearchProducts searches products by text ranked by relevance, it pages, filters and prices
+//products like GetProducts
+func (h *ProductHandler) SearchProducts(c echo.Context) error {
+	text := c.QueryParam("q")
+	if text == "" {
+		return c.JSON(http.StatusBadRequest, errorMessage{Message: "q is required"})
+	}
+	p, httpError := parsePagination(c.QueryParams())
+	if httpError == nil {
+		httpError = checkSort(p.Sort, productSortFields)
+	}
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if p.keyset {
+		return c.JSON(http.StatusBadRequest, errorMessage{Message: "search does not support cursor paging"})
+	}
+	hits, total, httpError := searchProducts(context.Background(), text, withoutKeys(c.QueryParams(), "q", displayCurrencyParam), p, h.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	refs := make([]*Product, len(hits))
+	for i := range hits {
+		refs[i] = &hits[i].Product
+	}
+	if httpError := h.priceProducts(c, refs...); httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	setPageHeaders(c, p, total)
+	return c.JSON(http.StatusOK, hits)
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+const (
+	attributeString  = "string"
+	attributeNumber  = "number"
+	attributeInteger = "integer"
+	attributeBoolean = "boolean"
+)
+
+var attributePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+//Attribute describes a spec the products of a category have, such as a screen size in inches.
+//Enum restricts the values of a string, Min and Max the range of a number or an integer.
+type Attribute struct {
+	Name     string   `json:"name" bson:"name" validate:"required,max=30,attribute"`
+	Type     string   `json:"type" bson:"type" validate:"required,oneof=string number integer boolean"`
+	Unit     string   `json:"unit,omitempty" bson:"unit,omitempty" validate:"max=10"`
+	Required bool     `json:"required" bson:"required"`
+	Enum     []string `json:"enum,omitempty" bson:"enum,omitempty" validate:"max=50,unique"`
+	Min      *float64 `json:"min,omitempty" bson:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty" bson:"max,omitempty"`
+}
+
+func isAttribute(fl validator.FieldLevel) bool {
+	return attributePattern.MatchString(fl.Field().String())
+}
+
+//validateAttribute checks that the constraints of an attribute suit its type
+func validateAttribute(sl validator.StructLevel) {
+	attr := sl.Current().Interface().(Attribute)
+	numeric := attr.Type == attributeNumber || attr.Type == attributeInteger
+	if len(attr.Enum) > 0 && attr.Type != attributeString {
+		sl.ReportError(attr.Enum, "enum", "Enum", "string", "")
+	}
+	if (attr.Min != nil || attr.Max != nil) && !numeric {
+		sl.ReportError(attr.Type, "type", "Type", "numeric", "")
+	}
+	if attr.Min != nil && attr.Max != nil && *attr.Min > *attr.Max {
+		sl.ReportError(*attr.Min, "min", "Min", "ltefield", "Max")
+	}
+}
+
+//validateCategory checks that the attributes of a category have distinct names
+func validateCategory(sl validator.StructLevel) {
+	category := sl.Current().Interface().(Category)
+	names := make(map[string]bool, len(category.Attributes))
+	for i, attr := range category.Attributes {
+		if names[attr.Name] {
+			field := fmt.Sprintf("Attributes[%d]", i)
+			sl.ReportError(attr.Name, field+".name", field+".Name", "unique", "")
+		}
+		names[attr.Name] = true
+	}
+}
+
+//loadCategories finds the categories of ids along with all their ancestors
+func loadCategories(ctx context.Context, ids []primitive.ObjectID, collection dbiface.CollectionAPI) (map[primitive.ObjectID]Category, *echo.HTTPError) {
+	loaded := make(map[primitive.ObjectID]Category)
+	for len(ids) > 0 {
+		cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		var categories []Category
+		if err == nil {
+			err = cursor.All(ctx, &categories)
+		}
+		if err != nil {
+			log.Errorf("Unable to find the categories : %v", err)
+			return loaded, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the categories"})
+		}
+		ids = nil
+		for _, category := range categories {
+			loaded[category.ID] = category
+		}
+		for _, category := range categories {
+			for _, ancestor := range category.Ancestors {
+				if _, ok := loaded[ancestor]; !ok && !containsID(ids, ancestor) {
+					ids = append(ids, ancestor)
+				}
+			}
+		}
+	}
+	return loaded, nil
+}
+
+//specSchema is the attributes of the categories of a product and of their ancestors, a
+//category overrides the attributes of the same name of its ancestors
+func specSchema(categories []primitive.ObjectID, loaded map[primitive.ObjectID]Category) map[string]Attribute {
+	schema := make(map[string]Attribute)
+	for _, id := range categories {
+		category := loaded[id]
+		for _, ancestor := range append(append([]primitive.ObjectID{}, category.Ancestors...), id) {
+			for _, attr := range loaded[ancestor].Attributes {
+				schema[attr.Name] = attr
+			}
+		}
+	}
+	return schema
+}
+
+func specNumber(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+//specValue checks value against attr and returns it in the form it is stored in
+func specValue(attr Attribute, value interface{}) (interface{}, error) {
+	switch attr.Type {
+	case attributeString:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a string", attr.Name)
+		}
+		if len(attr.Enum) == 0 {
+			return s, nil
+		}
+		for _, allowed := range attr.Enum {
+			if s == allowed {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("%s must be one of %v", attr.Name, attr.Enum)
+	case attributeBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a boolean", attr.Name)
+		}
+		return b, nil
+	}
+	n, ok := specNumber(value)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a %s", attr.Name, attr.Type)
+	}
+	if attr.Type == attributeInteger && n != math.Trunc(n) {
+		return nil, fmt.Errorf("%s must be an integer", attr.Name)
+	}
+	if attr.Min != nil && n < *attr.Min {
+		return nil, fmt.Errorf("%s must be at least %s", attr.Name, strconv.FormatFloat(*attr.Min, 'f', -1, 64))
+	}
+	if attr.Max != nil && n > *attr.Max {
+		return nil, fmt.Errorf("%s must be at most %s", attr.Name, strconv.FormatFloat(*attr.Max, 'f', -1, 64))
+	}
+	if attr.Type == attributeInteger {
+		return int64(n), nil
+	}
+	return n, nil
+}
+
+//validateSpecs checks specs against schema: every spec must be an attribute of the schema and
+//every required attribute must be given. It returns the specs as they are stored.
+func validateSpecs(specs map[string]interface{}, schema map[string]Attribute) (map[string]interface{}, error) {
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	valid := make(map[string]interface{}, len(specs))
+	for _, name := range names {
+		attr, ok := schema[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown spec %s", name)
+		}
+		value, err := specValue(attr, specs[name])
+		if err != nil {
+			return nil, err
+		}
+		valid[name] = value
+	}
+	required := make([]string, 0)
+	for name, attr := range schema {
+		if _, ok := specs[name]; attr.Required && !ok {
+			required = append(required, name)
+		}
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		return nil, fmt.Errorf("missing required specs %v", required)
+	}
+	if len(valid) == 0 {
+		return nil, nil
+	}
+	return valid, nil
+}
+
+//checkSpecs validates the specs of product against the schema of its categories and stores
+//them in the form they are stored
+func checkSpecs(ctx context.Context, product *Product, categoryCol dbiface.CollectionAPI) *echo.HTTPError {
+	loaded, httpError := loadCategories(ctx, product.Categories, categoryCol)
+	if httpError != nil {
+		return httpError
+	}
+	specs, err := validateSpecs(product.Specs, specSchema(product.Categories, loaded))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: fmt.Sprintf("invalid specs: %v", err)})
+	}
+	product.Specs = specs
+	return nil
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSpecs(t *testing.T) {
+	var computers, laptops Category
+
+	t.Run("validate attributes", func(t *testing.T) {
+		min, max := 1.0, 0.0
+		for name, tc := range map[string]struct {
+			attributes []Attribute
+			valid      bool
+		}{
+			"valid":          {[]Attribute{{Name: "ram", Type: "integer", Unit: "GB", Min: &min}, {Name: "os", Type: "string", Enum: []string{"linux", "windows"}}}, true},
+			"invalid name":   {[]Attribute{{Name: "Screen Size", Type: "number"}}, false},
+			"unknown type":   {[]Attribute{{Name: "ram", Type: "bytes"}}, false},
+			"duplicate name": {[]Attribute{{Name: "ram", Type: "integer"}, {Name: "ram", Type: "number"}}, false},
+			"numeric enum":   {[]Attribute{{Name: "ram", Type: "integer", Enum: []string{"8"}}}, false},
+			"string range":   {[]Attribute{{Name: "os", Type: "string", Min: &min}}, false},
+			"empty range":    {[]Attribute{{Name: "ram", Type: "integer", Min: &min, Max: &max}}, false},
+		} {
+			err := v.Struct(Category{Name: "Laptops", Slug: "laptops", Attributes: tc.attributes})
+			assert.Equal(t, tc.valid, err == nil, name)
+		}
+	})
+
+	t.Run("validate specs", func(t *testing.T) {
+		min, max := 1.0, 64.0
+		schema := map[string]Attribute{
+			"ram":         {Name: "ram", Type: "integer", Required: true, Min: &min, Max: &max},
+			"screen_size": {Name: "screen_size", Type: "number"},
+			"os":          {Name: "os", Type: "string", Enum: []string{"linux", "windows"}},
+			"touch":       {Name: "touch", Type: "boolean"},
+		}
+		for name, tc := range map[string]struct {
+			specs map[string]interface{}
+			valid bool
+		}{
+			"valid":           {map[string]interface{}{"ram": 16.0, "screen_size": 13.3, "os": "linux", "touch": true}, true},
+			"unknown spec":    {map[string]interface{}{"ram": 16.0, "colour": "black"}, false},
+			"missing ram":     {map[string]interface{}{"screen_size": 13.3}, false},
+			"fractional ram":  {map[string]interface{}{"ram": 15.5}, false},
+			"too much ram":    {map[string]interface{}{"ram": 128.0}, false},
+			"ram as a string": {map[string]interface{}{"ram": "16"}, false},
+			"unknown os":      {map[string]interface{}{"ram": 16.0, "os": "macos"}, false},
+			"touch as number": {map[string]interface{}{"ram": 16.0, "touch": 1.0}, false},
+		} {
+			_, err := validateSpecs(tc.specs, schema)
+			assert.Equal(t, tc.valid, err == nil, name)
+		}
+		specs, err := validateSpecs(map[string]interface{}{"ram": 16.0}, schema)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(16), specs["ram"])
+	})
+
+	t.Run("filter on specs", func(t *testing.T) {
+		filter, httpError := productFilter(map[string][]string{
+			"specs.ram[gte]":  {"8"},
+			"specs.os":        {"linux"},
+			"specs.touch":     {"true"},
+			"specs.ports":     {"USB-C,HDMI"},
+			"specs.cores[ne]": {"4"},
+			"specs.bays[in]":  {"1,2"},
+		})
+		assert.Nil(t, httpError)
+		assert.Equal(t, bson.M{"$gte": 8.0}, filter["specs.ram"])
+		assert.Equal(t, "linux", filter["specs.os"])
+		assert.Equal(t, bson.M{"$in": bson.A{true, "true"}}, filter["specs.touch"])
+		assert.Equal(t, "USB-C,HDMI", filter["specs.ports"])
+		assert.Equal(t, bson.M{"$nin": bson.A{4.0, "4"}}, filter["specs.cores"])
+		assert.Equal(t, bson.M{"$in": bson.A{1.0, "1", 2.0, "2"}}, filter["specs.bays"])
+		_, httpError = productFilter(map[string][]string{"specs.$where": {"1"}})
+		assert.NotNil(t, httpError)
+		for _, q := range []map[string][]string{
+			{"specs.ram": {"8"}, "specs.ram[in]": {"4,16"}},
+			{"specs.cores[ne]": {"4"}, "specs.cores[nin]": {"8"}},
+			{"price": {"5"}, "price[eq]": {"6"}},
+		} {
+			_, httpError = productFilter(q)
+			if assert.NotNil(t, httpError) {
+				assert.Equal(t, http.StatusBadRequest, httpError.Code)
+			}
+		}
+		filter, httpError = productFilter(map[string][]string{"specs.ram[gte]": {"8"}, "specs.ram[lt]": {"32"}})
+		assert.Nil(t, httpError)
+		assert.Equal(t, bson.M{"$gte": 8.0, "$lt": 32.0}, filter["specs.ram"])
+	})
+
+	t.Run("create products with specs", func(t *testing.T) {
+		computers = createCategory(t, `{"name":"Computers","attributes":[{"name":"ram","type":"integer","unit":"GB","required":true,"min":1}]}`)
+		laptops = createCategory(t, fmt.Sprintf(`{"name":"Laptops","parent_id":"%s","attributes":[
+			{"name":"screen_size","type":"number","unit":"in"},
+			{"name":"os","type":"string","enum":["linux","windows"]}
+		]}`, computers.ID.Hex()))
+		var results []itemResult
+		body := fmt.Sprintf(`[
+			{"product_name":"xps","price":"1299","currency":"USD","vendor":"sony","categories":["%[1]s"],"specs":{"ram":16,"screen_size":13.4,"os":"linux"}},
+			{"product_name":"vaio","price":"899","currency":"USD","vendor":"sony","categories":["%[1]s"],"specs":{"ram":8}},
+			{"product_name":"notebook","price":"499","currency":"USD","vendor":"sony","categories":["%[1]s"],"specs":{"screen_size":11.6}},
+			{"product_name":"netbook","price":"299","currency":"USD","vendor":"sony","categories":["%[1]s"],"specs":{"ram":2,"os":"dos"}}
+		]`, laptops.ID.Hex())
+		req := httptest.NewRequest(http.MethodPost, "/products?ordered=false", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.CreateProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusMultiStatus, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &results)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, results[0].Status)
+		assert.Equal(t, http.StatusCreated, results[1].Status)
+		assert.Equal(t, http.StatusBadRequest, results[2].Status)
+		assert.Equal(t, http.StatusBadRequest, results[3].Status)
+	})
+
+	t.Run("page products by a sparse spec", func(t *testing.T) {
+		total, err := col.CountDocuments(context.Background(), bson.M{"deleted_at": nil})
+		assert.Nil(t, err)
+		seen := int64(0)
+		next := ""
+		for page := 0; page <= int(total); page++ {
+			var body cursorPage
+			req := httptest.NewRequest(http.MethodGet, "/products?limit=1&sort=-specs.ram&cursor="+next, nil)
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			h.Col = col
+			err := h.GetProducts(c)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, res.Code)
+			err = json.Unmarshal(res.Body.Bytes(), &body)
+			assert.Nil(t, err)
+			seen += int64(len(body.Products))
+			if next = body.NextCursor; next == "" {
+				break
+			}
+		}
+		assert.Equal(t, total, seen)
+	})
+
+	t.Run("get products by specs", func(t *testing.T) {
+		var products []Product
+		req := httptest.NewRequest(http.MethodGet, "/products?specs.ram[gte]=12&specs.os=linux", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.GetProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &products)
+		assert.Nil(t, err)
+		if assert.Len(t, products, 1) {
+			assert.Equal(t, "xps", products[0].Name)
+		}
+		_, err = col.DeleteMany(context.Background(), bson.M{"categories": laptops.ID})
+		assert.Nil(t, err)
+		_, err = catCol.DeleteMany(context.Background(), bson.M{"_id": bson.M{"$in": bson.A{computers.ID, laptops.ID}}})
+		assert.Nil(t, err)
+	})
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	//maxPrefixLen is the longest prefix stored for a term, longer prefixes are matched by regex
+	maxPrefixLen       = 20
+	defaultSuggestions = 10
+	maxSuggestions     = 50
+)
+
+//Suggestion is a product name or vendor offered while the user types.
+//Every term is stored once with all of its prefixes so that a lookup is a single
+//equality match on the prefixes index, ranked by the number of products using the term
+//and then by how recently it was used.
+type Suggestion struct {
+	Text      string    `json:"text" bson:"term"`
+	Kind      string    `json:"kind" bson:"kind"`
+	Key       string    `json:"-" bson:"key"`
+	Prefixes  []string  `json:"-" bson:"prefixes"`
+	Count     int64     `json:"-" bson:"count"`
+	UpdatedAt time.Time `json:"-" bson:"updated_at"`
+	RebuiltAt time.Time `json:"-" bson:"rebuilt_at,omitempty"`
+}
+
+func suggestionKey(term string) string {
+	return strings.ToLower(strings.TrimSpace(term))
+}
+
+func prefixesOf(key string) []string {
+	var prefixes []string
+	runes := []rune(key)
+	for n := 1; n <= len(runes) && n <= maxPrefixLen; n++ {
+		prefixes = append(prefixes, string(runes[:n]))
+	}
+	return prefixes
+}
+
+//recordSuggestions adds delta to the popularity of the names and vendors of products
+func recordSuggestions(ctx context.Context, products []Product, delta int64, collection dbiface.CollectionAPI) error {
+	upsert := delta > 0
+	for _, product := range products {
+		for kind, term := range map[string]string{"product_name": product.Name, "vendor": product.Vendor} {
+			key := suggestionKey(term)
+			if key == "" {
+				continue
+			}
+			update := bson.M{
+				"$inc": bson.M{"count": delta},
+				"$set": bson.M{"term": term, "kind": kind, "key": key, "prefixes": prefixesOf(key), "updated_at": now()},
+			}
+			_, err := collection.UpdateOne(ctx, bson.M{"_id": kind + ":" + key}, update,
+				&options.UpdateOptions{Upsert: &upsert})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//refreshSuggestions moves the popularity from the terms of before to the terms of after
+func refreshSuggestions(ctx context.Context, before, after Product, collection dbiface.CollectionAPI) {
+	if before.Name == after.Name && before.Vendor == after.Vendor {
+		return
+	}
+	if err := recordSuggestions(ctx, []Product{before}, -1, collection); err != nil {
+		log.Errorf("Unable to update the suggestions : %v", err)
+	}
+	if err := recordSuggestions(ctx, []Product{after}, 1, collection); err != nil {
+		log.Errorf("Unable to update the suggestions : %v", err)
+	}
+}
+
+//rebuildSuggestions recomputes the popularity of the names and vendors from the products not in
+//the trash and drops the terms no product uses any more. It returns the number of terms.
+func rebuildSuggestions(ctx context.Context, collection, suggestCol dbiface.CollectionAPI) (int64, error) {
+	start := now()
+	var terms int64
+	for _, kind := range []string{"product_name", "vendor"} {
+		cursor, err := collection.Aggregate(ctx, bson.A{
+			bson.M{"$match": bson.M{"deleted_at": nil}},
+			bson.M{"$group": bson.M{
+				"_id":        "$" + kind,
+				"count":      bson.M{"$sum": 1},
+				"updated_at": bson.M{"$max": "$updated_at"},
+			}},
+		})
+		if err != nil {
+			return terms, err
+		}
+		var groups []struct {
+			Term      interface{} `bson:"_id"`
+			Count     int64       `bson:"count"`
+			UpdatedAt time.Time   `bson:"updated_at"`
+		}
+		if err := cursor.All(ctx, &groups); err != nil {
+			return terms, err
+		}
+		byKey := make(map[string]*Suggestion)
+		for _, group := range groups {
+			term, _ := group.Term.(string)
+			key := suggestionKey(term)
+			if key == "" {
+				continue
+			}
+			suggestion, ok := byKey[key]
+			if !ok {
+				suggestion = &Suggestion{Text: term, Kind: kind, Key: key, Prefixes: prefixesOf(key), RebuiltAt: start}
+				byKey[key] = suggestion
+			}
+			suggestion.Count += group.Count
+			if group.UpdatedAt.After(suggestion.UpdatedAt) {
+				suggestion.UpdatedAt = group.UpdatedAt
+			}
+		}
+		upsert := true
+		for key, suggestion := range byKey {
+			if suggestion.UpdatedAt.IsZero() {
+				suggestion.UpdatedAt = start
+			}
+			_, err := suggestCol.UpdateOne(ctx, bson.M{"_id": kind + ":" + key}, bson.M{"$set": suggestion},
+				&options.UpdateOptions{Upsert: &upsert})
+			if err != nil {
+				return terms, err
+			}
+			terms++
+		}
+	}
+	//terms recorded by writes since the rebuild started are newer than start and kept
+	_, err := suggestCol.DeleteMany(ctx, bson.M{"rebuilt_at": bson.M{"$ne": start}, "updated_at": bson.M{"$lt": start}})
+	return terms, err
+}
+
+//RebuildSuggestions recomputes the suggestions from the catalog, such as the products stored
+//before suggestions existed
+func (h *ProductHandler) RebuildSuggestions(c echo.Context) error {
+	terms, err := rebuildSuggestions(context.Background(), h.Col, h.SuggestCol)
+	if err != nil {
+		log.Errorf("Unable to rebuild the suggestions : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to rebuild the suggestions"})
+	}
+	return c.JSON(http.StatusOK, bson.M{"terms": terms})
+}
+
+func findSuggestions(ctx context.Context, prefix string, limit int64, collection dbiface.CollectionAPI) ([]Suggestion, *echo.HTTPError) {
+	suggestions := []Suggestion{}
+	key := suggestionKey(prefix)
+	filter := bson.M{"count": bson.M{"$gt": 0}}
+	if utf8.RuneCountInString(key) > maxPrefixLen {
+		filter["prefixes"] = string([]rune(key)[:maxPrefixLen])
+		filter["key"] = bson.M{"$regex": "^" + regexp.QuoteMeta(key)}
+	} else {
+		filter["prefixes"] = key
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "count", Value: -1}, {Key: "updated_at", Value: -1}}).
+		SetLimit(limit)
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Errorf("Unable to find the suggestions : %v", err)
+		return suggestions,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the suggestions"})
+	}
+	if err = cursor.All(ctx, &suggestions); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return suggestions,
+			echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse retrieved suggestions"})
+	}
+	return suggestions, nil
+}
+
+//SuggestProducts returns up to limit product names and vendors starting with prefix
+func (h *ProductHandler) SuggestProducts(c echo.Context) error {
+	prefix := c.QueryParam("prefix")
+	if suggestionKey(prefix) == "" {
+		return c.JSON(http.StatusBadRequest, errorMessage{Message: "prefix is required"})
+	}
+	limit := int64(defaultSuggestions)
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 1 || n > maxSuggestions {
+			return c.JSON(http.StatusBadRequest, errorMessage{Message: "limit must be between 1 and 50"})
+		}
+		limit = n
+	}
+	suggestions, httpError := findSuggestions(context.Background(), prefix, limit, h.SuggestCol)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	return c.JSON(http.StatusOK, suggestions)
+}
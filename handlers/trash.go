@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//GetTrash gets a page of deleted products, it filters and pages like GetProducts
+func (h *ProductHandler) GetTrash(c echo.Context) error {
+	filter, httpError := parseFilter(withoutKeys(c.QueryParams(), displayCurrencyParam), productFields)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	filter["deleted_at"] = bson.M{"$ne": nil}
+	return h.listProducts(c, filter)
+}
+
+func restoreProduct(ctx context.Context, id string, collection dbiface.CollectionAPI) (Product, Product, *echo.HTTPError) {
+	var previous Product
+	docID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Errorf("Unable to convert to Object ID : %v", err)
+		return previous, previous,
+			echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "unable to convert to ObjectID"})
+	}
+	res := collection.FindOne(ctx, bson.M{"_id": docID, "deleted_at": bson.M{"$ne": nil}})
+	if err := res.Decode(&previous); err != nil {
+		log.Errorf("Unable to find the product in the trash : %v", err)
+		return previous, previous,
+			echo.NewHTTPError(http.StatusNotFound, errorMessage{Message: "unable to find the product in the trash"})
+	}
+	product := previous
+	product.Version++
+	product.UpdatedAt = now()
+	product.DeletedAt, product.DeletedBy = nil, ""
+	update := bson.M{
+		"$unset": bson.M{"deleted_at": "", "deleted_by": ""},
+		"$set":   bson.M{"updated_at": product.UpdatedAt},
+		"$inc":   bson.M{"version": 1},
+	}
+	result, err := collection.UpdateOne(ctx, versionFilter(docID, previous.Version), update)
+	if err != nil {
+		log.Errorf("Unable to restore the product : %v", err)
+		return product, previous,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to restore the product"})
+	}
+	if result.MatchedCount == 0 {
+		return product, previous, versionConflict("")
+	}
+	return product, previous, nil
+}
+
+//RestoreProduct takes a product out of the trash
+func (h *ProductHandler) RestoreProduct(c echo.Context) error {
+	product, previous, httpError := restoreProduct(context.Background(), c.Param("id"), h.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if httpError := recordRevision(c, revisionRestore, &previous, product, h.RevCol); httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if err := recordSuggestions(context.Background(), []Product{product}, 1, h.SuggestCol); err != nil {
+		log.Errorf("Unable to update the suggestions : %v", err)
+	}
+	c.Response().Header().Set(headerETag, productETag(product.Version))
+	return c.JSON(http.StatusOK, product)
+}
+
+//PurgeDeletedProducts hard deletes the products which have been in the trash for longer than
+//retention along with their stock, their revisions and the images no other product lists.
+//Suggestions no product uses any more are dropped too.
+func (h *ProductHandler) PurgeDeletedProducts(ctx context.Context, retention time.Duration) (int64, error) {
+	filter := bson.M{"deleted_at": bson.M{"$lt": now().Add(-retention)}}
+	cursor, err := h.Col.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1, "images": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var products []Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return 0, err
+	}
+	if len(products) == 0 {
+		return 0, nil
+	}
+	ids := make([]primitive.ObjectID, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	filter["_id"] = bson.M{"$in": ids}
+	res, err := h.Col.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	//products restored in between are still there and keep everything
+	cursor, err = h.Col.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"_id": 1}))
+	var remaining []Product
+	if err == nil {
+		err = cursor.All(ctx, &remaining)
+	}
+	if err != nil {
+		return res.DeletedCount, err
+	}
+	kept := make(map[primitive.ObjectID]bool, len(remaining))
+	for _, product := range remaining {
+		kept[product.ID] = true
+	}
+	var purged, images []primitive.ObjectID
+	for _, product := range products {
+		if !kept[product.ID] {
+			purged = append(purged, product.ID)
+			images = append(images, product.Images...)
+		}
+	}
+	if _, err := h.StockCol.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": purged}}); err != nil {
+		return res.DeletedCount, err
+	}
+	for _, collection := range []dbiface.CollectionAPI{h.LocationCol, h.RevCol} {
+		if _, err := collection.DeleteMany(ctx, bson.M{"product_id": bson.M{"$in": purged}}); err != nil {
+			return res.DeletedCount, err
+		}
+	}
+	if _, err := h.SuggestCol.DeleteMany(ctx, bson.M{"count": bson.M{"$lte": 0}}); err != nil {
+		return res.DeletedCount, err
+	}
+	if err := touchCatalog(ctx, h.ChangeCol); err != nil {
+		return res.DeletedCount, err
+	}
+	return res.DeletedCount, purgeImages(ctx, images, h.Col, h.Images)
+}
+
+//purgeImages deletes the images, and their thumbnails, which no product lists any more.
+//Images are shared between products since they are deduplicated by checksum.
+func purgeImages(ctx context.Context, ids []primitive.ObjectID, collection dbiface.CollectionAPI, bucket dbiface.BucketAPI) error {
+	for _, id := range ids {
+		n, err := collection.CountDocuments(ctx, bson.M{"images": id})
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		cursor, err := bucket.Find(bson.M{"$or": bson.A{bson.M{"_id": id}, bson.M{"metadata.thumbnail_of": id}}})
+		if err != nil {
+			return err
+		}
+		var files []ProductImage
+		if err := cursor.All(ctx, &files); err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := bucket.Delete(file.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -60,6 +60,20 @@ func (u User) createToken() (string, error) {
 	return token, nil
 }
 
+//actor is the user_id claim of the JWT which authenticated the request, if any
+func actor(c echo.Context) string {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return ""
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	userID, _ := claims["user_id"].(string)
+	return userID
+}
+
 func insertUser(ctx context.Context, user User, collection dbiface.CollectionAPI) (User, *echo.HTTPError) {
 	var newUser User
 	res := collection.FindOne(ctx, bson.M{"username": user.Email})
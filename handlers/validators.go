@@ -3,9 +3,23 @@ package handlers
 import "gopkg.in/go-playground/validator.v9"
 
 var (
-	v = validator.New()
+	v = newValidator()
 )
 
+func newValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterCustomTypeFunc(moneyValue, Money{})
+	validate.RegisterValidation("slug", isSlug)
+	validate.RegisterValidation("sku", isSKU)
+	validate.RegisterValidation("attribute", isAttribute)
+	validate.RegisterStructValidation(validateProduct, Product{})
+	validate.RegisterStructValidation(validatePromotion, Promotion{})
+	validate.RegisterStructValidation(validateGeoPoint, GeoPoint{})
+	validate.RegisterStructValidation(validateCategory, Category{})
+	validate.RegisterStructValidation(validateAttribute, Attribute{})
+	return validate
+}
+
 //ProductValidator a product validator
 type ProductValidator struct {
 	validator *validator.Validate
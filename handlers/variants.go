@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+var skuPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+//Variant is a sellable version of a product, such as a colour and storage size of a phone.
+//Options name what sets it apart, Price overrides the price of the product when set.
+type Variant struct {
+	SKU     string            `json:"sku" bson:"sku" validate:"required,max=64,sku"`
+	Options map[string]string `json:"options" bson:"options" validate:"required,min=1,max=10,dive,keys,required,max=30,endkeys,required,max=50"`
+	Price   *Money            `json:"price,omitempty" bson:"price,omitempty"`
+	Stock   int64             `json:"stock" bson:"stock" validate:"min=0"`
+}
+
+func isSKU(fl validator.FieldLevel) bool {
+	return skuPattern.MatchString(fl.Field().String())
+}
+
+//optionKey identifies the combination of options of a variant
+func optionKey(options map[string]string) string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = strconv.Quote(name) + "=" + strconv.Quote(options[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+//optionNames identifies the names of the options of a variant
+func optionNames(options map[string]string) string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+//validateVariants checks the variants of product together: their SKUs and their combinations
+//of options are unique, they all have the same option names and their prices suit the
+//currency of the product, whose minor unit is exp
+func validateVariants(sl validator.StructLevel, product Product, exp int) {
+	skus := make(map[string]bool, len(product.Variants))
+	combinations := make(map[string]bool, len(product.Variants))
+	for i, variant := range product.Variants {
+		field := fmt.Sprintf("Variants[%d]", i)
+		if skus[variant.SKU] {
+			sl.ReportError(variant.SKU, field+".sku", field+".SKU", "unique", "")
+		}
+		skus[variant.SKU] = true
+		key := optionKey(variant.Options)
+		if combinations[key] {
+			sl.ReportError(variant.Options, field+".options", field+".Options", "unique", "")
+		}
+		combinations[key] = true
+		if i > 0 && optionNames(variant.Options) != optionNames(product.Variants[0].Options) {
+			sl.ReportError(variant.Options, field+".options", field+".Options", "eqfield", "Variants[0].Options")
+		}
+		if variant.Price == nil {
+			continue
+		}
+		if variant.Price.Sign() < 0 {
+			sl.ReportError(*variant.Price, field+".price", field+".Price", "gte", "0")
+		}
+		if variant.Price.decimals() > exp {
+			sl.ReportError(*variant.Price, field+".price", field+".Price", "exponent", strconv.Itoa(exp))
+		}
+	}
+}
+
+//GetProductBySKU gets the product which has a variant with the SKU, like GetProduct
+func (h *ProductHandler) GetProductBySKU(c echo.Context) error {
+	var product Product
+	err := h.Col.FindOne(context.Background(), bson.M{"variants.sku": c.Param("sku"), "deleted_at": nil},
+		options.FindOne().SetProjection(bson.M{"_id": 1})).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, errorMessage{Message: "unable to find the product"})
+	}
+	if err != nil {
+		log.Errorf("Unable to find the product : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to find the product"})
+	}
+	c.SetParamNames("id")
+	c.SetParamValues(product.ID.Hex())
+	return h.GetProduct(c)
+}
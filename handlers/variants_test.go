@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestVariants(t *testing.T) {
+	t.Run("validate variants", func(t *testing.T) {
+		price, _ := ParseMoney("999")
+		product := Product{Name: "iphone", Price: price, Currency: "USD", Vendor: "apple"}
+		variant := func(sku, colour, storage, price string) Variant {
+			v := Variant{SKU: sku, Options: map[string]string{"colour": colour, "storage": storage}}
+			if price != "" {
+				p, _ := ParseMoney(price)
+				v.Price = &p
+			}
+			return v
+		}
+		for name, tc := range map[string]struct {
+			variants []Variant
+			valid    bool
+		}{
+			"valid":           {[]Variant{variant("IP-BLK-128", "black", "128GB", ""), variant("IP-BLK-256", "black", "256GB", "1099")}, true},
+			"duplicate sku":   {[]Variant{variant("IP-BLK-128", "black", "128GB", ""), variant("IP-BLK-128", "black", "256GB", "")}, false},
+			"same options":    {[]Variant{variant("IP-BLK-128", "black", "128GB", ""), variant("IP-BLK-128B", "black", "128GB", "")}, false},
+			"other options":   {[]Variant{variant("IP-BLK-128", "black", "128GB", ""), {SKU: "IP-RED", Options: map[string]string{"colour": "red"}}}, false},
+			"invalid sku":     {[]Variant{variant("IP BLK", "black", "128GB", "")}, false},
+			"price exponent":  {[]Variant{variant("IP-BLK-128", "black", "128GB", "999.999")}, false},
+			"negative price":  {[]Variant{variant("IP-BLK-128", "black", "128GB", "-1")}, false},
+			"missing options": {[]Variant{{SKU: "IP-BLK-128"}}, false},
+		} {
+			product.Variants = tc.variants
+			err := v.Struct(product)
+			assert.Equal(t, tc.valid, err == nil, name)
+		}
+	})
+
+	t.Run("create products with variants", func(t *testing.T) {
+		var results []itemResult
+		body := `[
+			{"product_name":"iphone","price":"999","currency":"USD","vendor":"apple","variants":[
+				{"sku":"IP-BLK-128","options":{"colour":"black","storage":"128GB"},"stock":5},
+				{"sku":"IP-BLK-256","options":{"colour":"black","storage":"256GB"},"price":"1099"}
+			]},
+			{"product_name":"iphonemini","price":"699","currency":"USD","vendor":"apple","variants":[
+				{"sku":"IP-BLK-128","options":{"colour":"black"}}
+			]}
+		]`
+		req := httptest.NewRequest(http.MethodPost, "/products?ordered=false", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e := echo.New()
+		c := e.NewContext(req, res)
+		h.Col = col
+		err := h.CreateProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusMultiStatus, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &results)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, results[0].Status)
+		assert.Equal(t, http.StatusConflict, results[1].Status)
+	})
+
+	t.Run("get a product by sku", func(t *testing.T) {
+		for sku, code := range map[string]int{"IP-BLK-256": http.StatusOK, "IP-RED-512": http.StatusNotFound} {
+			var product Product
+			req := httptest.NewRequest(http.MethodGet, "/products/sku/"+sku, nil)
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			c.SetParamNames("sku")
+			c.SetParamValues(sku)
+			h.Col = col
+			err := h.GetProductBySKU(c)
+			assert.Nil(t, err)
+			assert.Equal(t, code, res.Code, sku)
+			if code == http.StatusOK {
+				err = json.Unmarshal(res.Body.Bytes(), &product)
+				assert.Nil(t, err)
+				assert.Equal(t, "iphone", product.Name)
+				assert.Equal(t, "1099", product.Variants[1].Price.String())
+			}
+		}
+		_, err := col.DeleteMany(context.Background(), bson.M{"vendor": "apple"})
+		assert.Nil(t, err)
+	})
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+const (
+	vendorActive   = "active"
+	vendorInactive = "inactive"
+)
+
+var (
+	slugPattern    = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+//VendorContact is how to reach a vendor
+type VendorContact struct {
+	Email   string `json:"email,omitempty" bson:"email,omitempty" validate:"omitempty,email"`
+	Phone   string `json:"phone,omitempty" bson:"phone,omitempty" validate:"max=30"`
+	Website string `json:"website,omitempty" bson:"website,omitempty" validate:"omitempty,url"`
+}
+
+//Vendor makes products. Products refer to their vendor by Slug, only active vendors take
+//new products.
+type Vendor struct {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name      string             `json:"name" bson:"name" validate:"required,max=100"`
+	Slug      string             `json:"slug" bson:"slug" validate:"required,max=50,slug"`
+	Contact   VendorContact      `json:"contact" bson:"contact"`
+	Status    string             `json:"status" bson:"status" validate:"required,oneof=active inactive"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+//VendorsHandler a vendors handler
+type VendorsHandler struct {
+	Col     dbiface.CollectionAPI
+	ProdCol dbiface.CollectionAPI
+}
+
+func isSlug(fl validator.FieldLevel) bool {
+	return slugPattern.MatchString(fl.Field().String())
+}
+
+//slugify turns a name such as "Google Inc." into a slug such as google-inc
+func slugify(name string) string {
+	return strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+//activeVendors is the set of the slugs which are active vendors
+func activeVendors(ctx context.Context, slugs []string, collection dbiface.CollectionAPI) (map[string]bool, *echo.HTTPError) {
+	active := make(map[string]bool)
+	cursor, err := collection.Find(ctx, bson.M{"slug": bson.M{"$in": slugs}, "status": vendorActive},
+		options.Find().SetProjection(bson.M{"slug": 1}))
+	if err != nil {
+		log.Errorf("Unable to find the vendors : %v", err)
+		return active, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the vendors"})
+	}
+	var vendors []Vendor
+	if err := cursor.All(ctx, &vendors); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return active, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the vendors"})
+	}
+	for _, vendor := range vendors {
+		active[vendor.Slug] = true
+	}
+	return active, nil
+}
+
+//checkVendor fails with 400 Bad Request unless slug is an active vendor
+func checkVendor(ctx context.Context, slug string, collection dbiface.CollectionAPI) *echo.HTTPError {
+	active, httpError := activeVendors(ctx, []string{slug}, collection)
+	if httpError != nil {
+		return httpError
+	}
+	if !active[slug] {
+		return echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: fmt.Sprintf("unknown vendor %s", slug)})
+	}
+	return nil
+}
+
+//isDuplicateKey reports whether err is the violation of a unique index
+func isDuplicateKey(err error) bool {
+	if we, ok := err.(mongo.WriteException); ok {
+		for _, e := range we.WriteErrors {
+			if e.Code == 11000 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//findVendor finds a vendor by ID or by slug
+func findVendor(ctx context.Context, idOrSlug string, collection dbiface.CollectionAPI) (Vendor, *echo.HTTPError) {
+	var vendor Vendor
+	filter := bson.M{"slug": idOrSlug}
+	if docID, err := primitive.ObjectIDFromHex(idOrSlug); err == nil {
+		filter = bson.M{"_id": docID}
+	}
+	if err := collection.FindOne(ctx, filter).Decode(&vendor); err != nil {
+		log.Errorf("Unable to find the vendor : %v", err)
+		return vendor, echo.NewHTTPError(http.StatusNotFound, errorMessage{Message: "unable to find the vendor"})
+	}
+	return vendor, nil
+}
+
+//vendorInUse reports whether products, including the ones in the trash, refer to slug
+func vendorInUse(ctx context.Context, slug string, collection dbiface.CollectionAPI) (bool, *echo.HTTPError) {
+	count, err := collection.CountDocuments(ctx, bson.M{"vendor": slug}, options.Count().SetLimit(1))
+	if err != nil {
+		log.Errorf("Unable to count the products : %v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to count the products"})
+	}
+	return count > 0, nil
+}
+
+func decodeVendor(c echo.Context) (Vendor, *echo.HTTPError) {
+	var vendor Vendor
+	if err := json.NewDecoder(c.Request().Body).Decode(&vendor); err != nil {
+		log.Errorf("Unable to decode the vendor : %v", err)
+		return vendor, echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse request payload"})
+	}
+	if vendor.Slug == "" {
+		vendor.Slug = slugify(vendor.Name)
+	}
+	if vendor.Status == "" {
+		vendor.Status = vendorActive
+	}
+	if err := v.Struct(vendor); err != nil {
+		log.Errorf("Unable to validate the vendor %+v %v", vendor, err)
+		return vendor, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: err.Error()})
+	}
+	return vendor, nil
+}
+
+//GetVendors gets the vendors, filtered by status=active|inactive
+func (vh *VendorsHandler) GetVendors(c echo.Context) error {
+	vendors := []Vendor{}
+	filter := bson.M{}
+	if status := c.QueryParam("status"); status != "" {
+		if status != vendorActive && status != vendorInactive {
+			return c.JSON(http.StatusBadRequest, errorMessage{Message: "status must be active or inactive"})
+		}
+		filter["status"] = status
+	}
+	ctx := context.Background()
+	cursor, err := vh.Col.Find(ctx, filter, options.Find().SetSort(bson.M{"slug": 1}))
+	if err == nil {
+		err = cursor.All(ctx, &vendors)
+	}
+	if err != nil {
+		log.Errorf("Unable to find the vendors : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to find the vendors"})
+	}
+	return c.JSON(http.StatusOK, vendors)
+}
+
+//GetVendor gets a single vendor by ID or by slug
+func (vh *VendorsHandler) GetVendor(c echo.Context) error {
+	vendor, httpError := findVendor(context.Background(), c.Param("id"), vh.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	return c.JSON(http.StatusOK, vendor)
+}
+
+//CreateVendor creates a vendor, the slug is derived from the name when missing
+func (vh *VendorsHandler) CreateVendor(c echo.Context) error {
+	vendor, httpError := decodeVendor(c)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	vendor.ID = primitive.NewObjectID()
+	vendor.CreatedAt = now()
+	vendor.UpdatedAt = vendor.CreatedAt
+	if _, err := vh.Col.InsertOne(context.Background(), vendor); err != nil {
+		if isDuplicateKey(err) {
+			return c.JSON(http.StatusConflict, errorMessage{Message: fmt.Sprintf("vendor %s already exists", vendor.Slug)})
+		}
+		log.Errorf("Unable to insert the vendor : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to insert the vendor"})
+	}
+	return c.JSON(http.StatusCreated, vendor)
+}
+
+//UpdateVendor replaces a vendor, the slug of a vendor which has products cannot change
+func (vh *VendorsHandler) UpdateVendor(c echo.Context) error {
+	ctx := context.Background()
+	previous, httpError := findVendor(ctx, c.Param("id"), vh.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	vendor, httpError := decodeVendor(c)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if vendor.Slug != previous.Slug {
+		inUse, httpError := vendorInUse(ctx, previous.Slug, vh.ProdCol)
+		if httpError != nil {
+			return c.JSON(httpError.Code, httpError.Message)
+		}
+		if inUse {
+			return c.JSON(http.StatusConflict, errorMessage{Message: "the slug of a vendor with products cannot change"})
+		}
+	}
+	vendor.ID = previous.ID
+	vendor.CreatedAt = previous.CreatedAt
+	vendor.UpdatedAt = now()
+	update, err := changedFields(previous, vendor)
+	if err == nil {
+		_, err = vh.Col.UpdateOne(ctx, bson.M{"_id": vendor.ID}, update)
+	}
+	if isDuplicateKey(err) {
+		return c.JSON(http.StatusConflict, errorMessage{Message: fmt.Sprintf("vendor %s already exists", vendor.Slug)})
+	}
+	if err != nil {
+		log.Errorf("Unable to update the vendor : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to update the vendor"})
+	}
+	return c.JSON(http.StatusOK, vendor)
+}
+
+//DeleteVendor deletes a vendor which has no products, deactivate it otherwise
+func (vh *VendorsHandler) DeleteVendor(c echo.Context) error {
+	ctx := context.Background()
+	vendor, httpError := findVendor(ctx, c.Param("id"), vh.Col)
+	if httpError != nil {
+		if httpError.Code == http.StatusNotFound {
+			return c.JSON(http.StatusOK, 0)
+		}
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	inUse, httpError := vendorInUse(ctx, vendor.Slug, vh.ProdCol)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if inUse {
+		return c.JSON(http.StatusConflict, errorMessage{Message: "vendor has products, deactivate it instead"})
+	}
+	res, err := vh.Col.DeleteOne(ctx, bson.M{"_id": vendor.ID})
+	if err != nil {
+		log.Errorf("Unable to delete the vendor : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to delete the vendor"})
+	}
+	return c.JSON(http.StatusOK, res.DeletedCount)
+}
+
+//GetVendorProducts gets a page of the products of a vendor, it filters and pages like GetProducts
+func (h *ProductHandler) GetVendorProducts(c echo.Context) error {
+	vendor, httpError := findVendor(context.Background(), c.Param("id"), h.VendorCol)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	filter, httpError := productFilter(withoutKeys(c.QueryParams(), displayCurrencyParam, "vendor"))
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	filter["vendor"] = vendor.Slug
+	return h.listProducts(c, filter)
+}
+
+//MigrateVendors registers the vendors which products refer to but which are not vendors yet,
+//as active vendors named after them, and rewrites the vendor of the products stored before
+//vendors existed to its slug. It returns the number of rewritten products.
+func MigrateVendors(ctx context.Context, collection, vendorCol dbiface.CollectionAPI) (int64, error) {
+	cursor, err := collection.Aggregate(ctx, bson.A{bson.M{"$group": bson.M{"_id": "$vendor"}}})
+	if err != nil {
+		return 0, err
+	}
+	var groups []bson.M
+	if err := cursor.All(ctx, &groups); err != nil {
+		return 0, err
+	}
+	var rewritten int64
+	for _, group := range groups {
+		name, ok := group["_id"].(string)
+		slug := slugify(name)
+		if len(slug) > 50 {
+			slug = strings.Trim(slug[:50], "-")
+		}
+		if !ok || slug == "" {
+			log.Warnf("Unable to derive a vendor from %v", group["_id"])
+			continue
+		}
+		at := now()
+		vendor := Vendor{Name: name, Slug: slug, Status: vendorActive, CreatedAt: at, UpdatedAt: at}
+		_, err := vendorCol.UpdateOne(ctx, bson.M{"slug": slug}, bson.M{"$setOnInsert": vendor}, options.Update().SetUpsert(true))
+		if err != nil {
+			return rewritten, err
+		}
+		if name == slug {
+			continue
+		}
+		res, err := collection.UpdateMany(ctx, bson.M{"vendor": name}, bson.M{
+			"$set": bson.M{"vendor": slug, "updated_at": at},
+			"$inc": bson.M{"version": 1},
+		})
+		if err != nil {
+			return rewritten, err
+		}
+		rewritten += res.ModifiedCount
+	}
+	return rewritten, nil
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestVendors(t *testing.T) {
+	t.Run("slugify", func(t *testing.T) {
+		assert.Equal(t, "google-inc", slugify("Google Inc."))
+		assert.Equal(t, "bang-olufsen", slugify(" Bang & Olufsen "))
+	})
+
+	t.Run("create a vendor", func(t *testing.T) {
+		var vendor Vendor
+		body := `{"name":"Bang & Olufsen","contact":{"email":"sales@bang-olufsen.com"}}`
+		req := httptest.NewRequest(http.MethodPost, "/vendors", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		err := vh.CreateVendor(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &vendor)
+		assert.Nil(t, err)
+		assert.Equal(t, "bang-olufsen", vendor.Slug)
+		assert.Equal(t, vendorActive, vendor.Status)
+	})
+
+	t.Run("create a vendor unhappy", func(t *testing.T) {
+		for body, code := range map[string]int{
+			`{"name":"Sony"}`:                            http.StatusConflict,
+			`{"name":"Acme","slug":"Acme Corp"}`:         http.StatusBadRequest,
+			`{"name":"Acme","status":"closed"}`:          http.StatusBadRequest,
+			`{"name":"Acme","contact":{"email":"acme"}}`: http.StatusBadRequest,
+		} {
+			req := httptest.NewRequest(http.MethodPost, "/vendors", strings.NewReader(body))
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			err := vh.CreateVendor(c)
+			assert.Nil(t, err)
+			assert.Equal(t, code, res.Code, body)
+		}
+	})
+
+	t.Run("get the products of a vendor", func(t *testing.T) {
+		var products []Product
+		req := httptest.NewRequest(http.MethodGet, "/vendors/google/products", nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues("google")
+		err := h.GetVendorProducts(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &products)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, products)
+		for _, product := range products {
+			assert.Equal(t, "google", product.Vendor)
+		}
+	})
+
+	t.Run("change the slug of a vendor with products", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/vendors/google", strings.NewReader(`{"name":"Alphabet"}`))
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues("google")
+		err := vh.UpdateVendor(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusConflict, res.Code)
+	})
+
+	t.Run("delete vendors", func(t *testing.T) {
+		for id, code := range map[string]int{"google": http.StatusConflict, "bang-olufsen": http.StatusOK} {
+			req := httptest.NewRequest(http.MethodDelete, "/vendors/"+id, nil)
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+			err := vh.DeleteVendor(c)
+			assert.Nil(t, err)
+			assert.Equal(t, code, res.Code, id)
+		}
+	})
+
+	t.Run("migrate prices", func(t *testing.T) {
+		res, err := col.InsertMany(context.Background(), []interface{}{
+			bson.M{"product_name": "nokia 3310", "price": int64(59), "currency": "USD", "vendor": "nokia"},
+			bson.M{"product_name": "nokia 8110", "price": 79.99, "currency": "USD", "vendor": "nokia"},
+		})
+		assert.Nil(t, err)
+		n, err := MigratePrices(context.Background(), col)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), n)
+
+		for i, price := range []string{"59", "79.99"} {
+			var raw bson.Raw
+			err = col.FindOne(context.Background(), bson.M{"_id": res.InsertedIDs[i]}).Decode(&raw)
+			assert.Nil(t, err)
+			assert.Equal(t, bson.TypeDecimal128, raw.Lookup("price").Type)
+			var product Product
+			err = bson.Unmarshal(raw, &product)
+			assert.Nil(t, err)
+			assert.Equal(t, price, product.Price.String())
+		}
+		_, err = col.DeleteMany(context.Background(), bson.M{"_id": bson.M{"$in": res.InsertedIDs}})
+		assert.Nil(t, err)
+	})
+
+	t.Run("migrate vendors", func(t *testing.T) {
+		price, _ := ParseMoney("19.99")
+		res, err := col.InsertOne(context.Background(), Product{Name: "lumia", Price: price, Currency: "USD", Vendor: "Nokia Corp."})
+		assert.Nil(t, err)
+		n, err := MigrateVendors(context.Background(), col, vendorCol)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), n)
+
+		var product Product
+		err = col.FindOne(context.Background(), bson.M{"_id": res.InsertedID}).Decode(&product)
+		assert.Nil(t, err)
+		assert.Equal(t, "nokia-corp", product.Vendor)
+		assert.Equal(t, int64(1), product.Version)
+		var vendor Vendor
+		err = vendorCol.FindOne(context.Background(), bson.M{"slug": "nokia-corp"}).Decode(&vendor)
+		assert.Nil(t, err)
+		assert.Equal(t, "Nokia Corp.", vendor.Name)
+		assert.Equal(t, vendorActive, vendor.Status)
+
+		_, err = col.DeleteOne(context.Background(), bson.M{"_id": res.InsertedID})
+		assert.Nil(t, err)
+		_, err = vendorCol.DeleteOne(context.Background(), bson.M{"slug": "nokia-corp"})
+		assert.Nil(t, err)
+	})
+}
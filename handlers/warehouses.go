@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krunal4amity/tronicscorp/dbiface"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+const (
+	defaultLocations = 5
+	maxLocations     = 50
+)
+
+//GeoPoint is a GeoJSON point, Coordinates are longitude then latitude
+type GeoPoint struct {
+	Type        string    `json:"type" bson:"type"`
+	Coordinates []float64 `json:"coordinates" bson:"coordinates"`
+}
+
+//Warehouse is a place holding stock which customers can collect from
+type Warehouse struct {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name      string             `json:"name" bson:"name" validate:"required,max=50"`
+	Address   string             `json:"address,omitempty" bson:"address,omitempty" validate:"max=200"`
+	Location  GeoPoint           `json:"location" bson:"location"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+//LocationStock is the quantity of a product held by a warehouse. The name and location of
+//the warehouse are copied in so that the nearest locations are found by a single $geoNear.
+//Quantities held by warehouses are part of the stock on hand of the product: setting one adds
+//the difference to the reservable Stock, which may also hold products kept at no warehouse.
+type LocationStock struct {
+	WarehouseID primitive.ObjectID `json:"warehouse_id" bson:"warehouse_id"`
+	ProductID   primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Name        string             `json:"name" bson:"name"`
+	Location    GeoPoint           `json:"location" bson:"location"`
+	Quantity    int64              `json:"quantity" bson:"quantity"`
+	Distance    float64            `json:"distance,omitempty" bson:"distance,omitempty"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+//WarehousesHandler a warehouses handler
+type WarehousesHandler struct {
+	Col          dbiface.CollectionAPI
+	StockCol     dbiface.CollectionAPI
+	ProdCol      dbiface.CollectionAPI
+	InventoryCol dbiface.CollectionAPI
+}
+
+//validateGeoPoint checks that a point is a GeoJSON point on earth
+func validateGeoPoint(sl validator.StructLevel) {
+	point := sl.Current().Interface().(GeoPoint)
+	if point.Type != "Point" {
+		sl.ReportError(point.Type, "type", "Type", "eq", "Point")
+	}
+	if len(point.Coordinates) != 2 {
+		sl.ReportError(point.Coordinates, "coordinates", "Coordinates", "len", "2")
+		return
+	}
+	if lng := point.Coordinates[0]; lng < -180 || lng > 180 {
+		sl.ReportError(lng, "coordinates", "Coordinates", "longitude", "")
+	}
+	if lat := point.Coordinates[1]; lat < -90 || lat > 90 {
+		sl.ReportError(lat, "coordinates", "Coordinates", "latitude", "")
+	}
+}
+
+//parseNear reads a lat,lng pair into a GeoJSON point
+func parseNear(raw string) (GeoPoint, *echo.HTTPError) {
+	parts := strings.Split(raw, ",")
+	if len(parts) == 2 {
+		lat, latErr := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		lng, lngErr := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		point := GeoPoint{Type: "Point", Coordinates: []float64{lng, lat}}
+		if latErr == nil && lngErr == nil && v.Struct(point) == nil {
+			return point, nil
+		}
+	}
+	return GeoPoint{}, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "near must be lat,lng"})
+}
+
+//nearestLocations finds the warehouses holding product closest to near, within maxDistance
+//metres unless it is 0
+func nearestLocations(ctx context.Context, productID primitive.ObjectID, near GeoPoint, maxDistance float64, limit int64, collection dbiface.CollectionAPI) ([]LocationStock, *echo.HTTPError) {
+	locations := []LocationStock{}
+	geoNear := bson.M{
+		"near":          near,
+		"distanceField": "distance",
+		"spherical":     true,
+		"query":         bson.M{"product_id": productID, "quantity": bson.M{"$gt": 0}},
+	}
+	if maxDistance > 0 {
+		geoNear["maxDistance"] = maxDistance
+	}
+	pipeline := bson.A{bson.M{"$geoNear": geoNear}, bson.M{"$limit": limit}}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Errorf("Unable to find the nearest locations : %v", err)
+		return locations,
+			echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the nearest locations"})
+	}
+	if err = cursor.All(ctx, &locations); err != nil {
+		log.Errorf("Unable to read the cursor : %v", err)
+		return locations,
+			echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse retrieved locations"})
+	}
+	return locations, nil
+}
+
+//GetAvailability gets the warehouses holding a product nearest to near=lat,lng, closest first.
+//limit caps the number of locations and max_distance, in metres, how far they may be.
+func (h *ProductHandler) GetAvailability(c echo.Context) error {
+	near, httpError := parseNear(c.QueryParam("near"))
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	limit, found, httpError := parseCount(c.QueryParams(), "limit", 1)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if !found {
+		limit = defaultLocations
+	}
+	if limit > maxLocations {
+		return c.JSON(http.StatusBadRequest, errorMessage{Message: fmt.Sprintf("limit must be at most %d", maxLocations)})
+	}
+	var maxDistance float64
+	var err error
+	if raw := c.QueryParam("max_distance"); raw != "" {
+		if maxDistance, err = strconv.ParseFloat(raw, 64); err != nil || maxDistance <= 0 {
+			return c.JSON(http.StatusBadRequest, errorMessage{Message: "max_distance must be a positive number of metres"})
+		}
+	}
+	product, httpError := findProduct(context.Background(), c.Param("id"), h.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	locations, httpError := nearestLocations(context.Background(), product.ID, near, maxDistance, limit, h.LocationCol)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	return c.JSON(http.StatusOK, locations)
+}
+
+func decodeWarehouse(c echo.Context) (Warehouse, *echo.HTTPError) {
+	var warehouse Warehouse
+	if err := json.NewDecoder(c.Request().Body).Decode(&warehouse); err != nil {
+		log.Errorf("Unable to decode the warehouse : %v", err)
+		return warehouse, echo.NewHTTPError(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse request payload"})
+	}
+	if err := v.Struct(warehouse); err != nil {
+		log.Errorf("Unable to validate the warehouse %+v %v", warehouse, err)
+		return warehouse, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: err.Error()})
+	}
+	return warehouse, nil
+}
+
+func findWarehouse(ctx context.Context, id string, collection dbiface.CollectionAPI) (Warehouse, *echo.HTTPError) {
+	var warehouse Warehouse
+	docID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Errorf("Unable to convert to Object ID : %v", err)
+		return warehouse, echo.NewHTTPError(http.StatusBadRequest, errorMessage{Message: "unable to convert to ObjectID"})
+	}
+	if err := collection.FindOne(ctx, bson.M{"_id": docID}).Decode(&warehouse); err != nil {
+		log.Errorf("Unable to find the warehouse : %v", err)
+		return warehouse, echo.NewHTTPError(http.StatusNotFound, errorMessage{Message: "unable to find the warehouse"})
+	}
+	return warehouse, nil
+}
+
+//GetWarehouses gets the warehouses
+func (wh *WarehousesHandler) GetWarehouses(c echo.Context) error {
+	warehouses := []Warehouse{}
+	ctx := context.Background()
+	cursor, err := wh.Col.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err == nil {
+		err = cursor.All(ctx, &warehouses)
+	}
+	if err != nil {
+		log.Errorf("Unable to find the warehouses : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to find the warehouses"})
+	}
+	return c.JSON(http.StatusOK, warehouses)
+}
+
+//CreateWarehouse creates a warehouse
+func (wh *WarehousesHandler) CreateWarehouse(c echo.Context) error {
+	warehouse, httpError := decodeWarehouse(c)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	warehouse.ID = primitive.NewObjectID()
+	warehouse.CreatedAt = now()
+	warehouse.UpdatedAt = warehouse.CreatedAt
+	if _, err := wh.Col.InsertOne(context.Background(), warehouse); err != nil {
+		log.Errorf("Unable to insert the warehouse : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to insert the warehouse"})
+	}
+	return c.JSON(http.StatusCreated, warehouse)
+}
+
+//UpdateWarehouse replaces a warehouse and moves the stock it holds along with it
+func (wh *WarehousesHandler) UpdateWarehouse(c echo.Context) error {
+	ctx := context.Background()
+	previous, httpError := findWarehouse(ctx, c.Param("id"), wh.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	warehouse, httpError := decodeWarehouse(c)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	warehouse.ID = previous.ID
+	warehouse.CreatedAt = previous.CreatedAt
+	warehouse.UpdatedAt = now()
+	update, err := changedFields(previous, warehouse)
+	if err == nil {
+		_, err = wh.Col.UpdateOne(ctx, bson.M{"_id": warehouse.ID}, update)
+	}
+	if err == nil {
+		_, err = wh.StockCol.UpdateMany(ctx, bson.M{"warehouse_id": warehouse.ID},
+			bson.M{"$set": bson.M{"name": warehouse.Name, "location": warehouse.Location}})
+	}
+	if err != nil {
+		log.Errorf("Unable to update the warehouse : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to update the warehouse"})
+	}
+	return c.JSON(http.StatusOK, warehouse)
+}
+
+//withdrawLocations removes the stock held by a warehouse from the stock on hand of its
+//products, one location at a time so that a reserved quantity stops it with 409 Conflict
+//and leaves the remaining locations in place
+func withdrawLocations(ctx context.Context, warehouseID primitive.ObjectID, collection, inventoryCol dbiface.CollectionAPI) *echo.HTTPError {
+	var locations []LocationStock
+	cursor, err := collection.Find(ctx, bson.M{"warehouse_id": warehouseID})
+	if err == nil {
+		err = cursor.All(ctx, &locations)
+	}
+	if err != nil {
+		log.Errorf("Unable to find the stock of the warehouse : %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to find the stock of the warehouse"})
+	}
+	for _, location := range locations {
+		if location.Quantity > 0 {
+			if _, httpError := adjustStock(ctx, location.ProductID, -location.Quantity, inventoryCol); httpError != nil {
+				return httpError
+			}
+		}
+		_, err = collection.DeleteOne(ctx, bson.M{"warehouse_id": warehouseID, "product_id": location.ProductID})
+		if err != nil {
+			log.Errorf("Unable to delete the stock of the warehouse : %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, errorMessage{Message: "unable to delete the stock of the warehouse"})
+		}
+	}
+	return nil
+}
+
+//DeleteWarehouse deletes a warehouse along with the stock it holds, which is withdrawn from
+//the stock on hand of the products
+func (wh *WarehousesHandler) DeleteWarehouse(c echo.Context) error {
+	docID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		log.Errorf("Unable to convert to Object ID : %v", err)
+		return c.JSON(http.StatusBadRequest, errorMessage{Message: "unable to convert to ObjectID"})
+	}
+	ctx := context.Background()
+	if httpError := withdrawLocations(ctx, docID, wh.StockCol, wh.InventoryCol); httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	res, err := wh.Col.DeleteOne(ctx, bson.M{"_id": docID})
+	if err != nil {
+		log.Errorf("Unable to delete the warehouse : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to delete the warehouse"})
+	}
+	return c.JSON(http.StatusOK, res.DeletedCount)
+}
+
+//SetLocationStock sets the quantity of a product held by a warehouse and adds the difference
+//to the stock on hand, removing more than is available answers 409 Conflict
+func (wh *WarehousesHandler) SetLocationStock(c echo.Context) error {
+	var req struct {
+		Quantity *int64 `json:"quantity" validate:"required,min=0"`
+	}
+	ctx := context.Background()
+	warehouse, httpError := findWarehouse(ctx, c.Param("id"), wh.Col)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	productID, err := primitive.ObjectIDFromHex(c.Param("product_id"))
+	if err != nil {
+		log.Errorf("Unable to convert to Object ID : %v", err)
+		return c.JSON(http.StatusBadRequest, errorMessage{Message: "unable to convert to ObjectID"})
+	}
+	existing, httpError := existingProducts(ctx, []primitive.ObjectID{productID}, wh.ProdCol)
+	if httpError != nil {
+		return c.JSON(httpError.Code, httpError.Message)
+	}
+	if !existing[productID] {
+		return c.JSON(http.StatusNotFound, errorMessage{Message: "unable to find the product"})
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		log.Errorf("Unable to decode the quantity : %v", err)
+		return c.JSON(http.StatusUnprocessableEntity, errorMessage{Message: "unable to parse request payload"})
+	}
+	if err := v.Struct(req); err != nil {
+		log.Errorf("Unable to validate the quantity : %v", err)
+		return c.JSON(http.StatusBadRequest, errorMessage{Message: err.Error()})
+	}
+	stock := LocationStock{
+		WarehouseID: warehouse.ID,
+		ProductID:   productID,
+		Name:        warehouse.Name,
+		Location:    warehouse.Location,
+		Quantity:    *req.Quantity,
+		UpdatedAt:   now(),
+	}
+	filter := bson.M{"warehouse_id": warehouse.ID, "product_id": productID}
+	var previous LocationStock
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+	err = wh.StockCol.FindOneAndUpdate(ctx, filter, bson.M{"$set": stock}, opts).Decode(&previous)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Errorf("Unable to set the stock : %v", err)
+		return c.JSON(http.StatusInternalServerError, errorMessage{Message: "unable to set the stock"})
+	}
+	if delta := stock.Quantity - previous.Quantity; delta != 0 {
+		if _, httpError := adjustStock(ctx, productID, delta, wh.InventoryCol); httpError != nil {
+			//put the previous quantity back unless another request has changed it meanwhile
+			filter["quantity"] = stock.Quantity
+			if err == mongo.ErrNoDocuments {
+				_, err = wh.StockCol.DeleteOne(ctx, filter)
+			} else {
+				_, err = wh.StockCol.UpdateOne(ctx, filter, bson.M{"$set": previous})
+			}
+			if err != nil {
+				log.Errorf("Unable to restore the stock : %v", err)
+			}
+			return c.JSON(httpError.Code, httpError.Message)
+		}
+	}
+	return c.JSON(http.StatusOK, stock)
+}
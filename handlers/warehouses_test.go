@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func setLocationStock(t *testing.T, warehouseID, productID string, quantity int) int {
+	req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/warehouses/%s/stock/%s", warehouseID, productID),
+		strings.NewReader(fmt.Sprintf(`{"quantity":%d}`, quantity)))
+	res := httptest.NewRecorder()
+	e := echo.New()
+	c := e.NewContext(req, res)
+	c.SetParamNames("id", "product_id")
+	c.SetParamValues(warehouseID, productID)
+	err := wh.SetLocationStock(c)
+	assert.Nil(t, err)
+	return res.Code
+}
+
+func TestWarehouses(t *testing.T) {
+	var warehouseIDs []string
+	productID := primitive.NewObjectID()
+
+	t.Run("create warehouses", func(t *testing.T) {
+		price, _ := ParseMoney("10")
+		_, err := col.InsertOne(context.Background(), Product{ID: productID, Name: "cable", Price: price, Currency: "USD", Vendor: "belkin"})
+		assert.Nil(t, err)
+		for _, body := range []string{
+			`{"name":"paris","location":{"type":"Point","coordinates":[2.3522,48.8566]}}`,
+			`{"name":"lyon","location":{"type":"Point","coordinates":[4.8357,45.7640]}}`,
+			`{"name":"berlin","location":{"type":"Point","coordinates":[13.4050,52.5200]}}`,
+		} {
+			var warehouse Warehouse
+			req := httptest.NewRequest(http.MethodPost, "/warehouses", strings.NewReader(body))
+			res := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, res)
+			err := wh.CreateWarehouse(c)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusCreated, res.Code)
+			err = json.Unmarshal(res.Body.Bytes(), &warehouse)
+			assert.Nil(t, err)
+			warehouseIDs = append(warehouseIDs, warehouse.ID.Hex())
+		}
+	})
+
+	t.Run("create warehouse invalid location unhappy", func(t *testing.T) {
+		body := `{"name":"nowhere","location":{"type":"Point","coordinates":[200,10]}}`
+		req := httptest.NewRequest(http.MethodPost, "/warehouses", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		err := wh.CreateWarehouse(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("set location stock", func(t *testing.T) {
+		for i, quantity := range []int{4, 0, 9} {
+			assert.Equal(t, http.StatusOK, setLocationStock(t, warehouseIDs[i], productID.Hex(), quantity))
+		}
+		assert.Equal(t, http.StatusOK, setLocationStock(t, warehouseIDs[2], productID.Hex(), 7))
+		stock, httpError := findStock(context.Background(), productID, stockCol)
+		assert.Nil(t, httpError)
+		assert.Equal(t, int64(11), stock.OnHand)
+	})
+
+	t.Run("set location stock of an unknown product unhappy", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, setLocationStock(t, warehouseIDs[0], primitive.NewObjectID().Hex(), 1))
+	})
+
+	t.Run("set location stock below the reserved stock unhappy", func(t *testing.T) {
+		_, httpError := reserveStock(context.Background(), productID, 8, stockCol)
+		assert.Nil(t, httpError)
+		assert.Equal(t, http.StatusConflict, setLocationStock(t, warehouseIDs[2], productID.Hex(), 2))
+		var location LocationStock
+		warehouseID, _ := primitive.ObjectIDFromHex(warehouseIDs[2])
+		err := locCol.FindOne(context.Background(), bson.M{"warehouse_id": warehouseID, "product_id": productID}).Decode(&location)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(7), location.Quantity)
+		_, httpError = releaseStock(context.Background(), productID, 8, stockCol)
+		assert.Nil(t, httpError)
+	})
+
+	t.Run("get nearest availability", func(t *testing.T) {
+		var locations []LocationStock
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/products/%s/availability?near=45.75,4.85", productID.Hex()), nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(productID.Hex())
+		h.Col = col
+		err := h.GetAvailability(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		err = json.Unmarshal(res.Body.Bytes(), &locations)
+		assert.Nil(t, err)
+		assert.Len(t, locations, 2)
+		assert.Equal(t, "paris", locations[0].Name)
+		assert.Equal(t, "berlin", locations[1].Name)
+		assert.True(t, locations[0].Distance < locations[1].Distance)
+	})
+
+	t.Run("get availability without near unhappy", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/products/%s/availability?near=north", productID.Hex()), nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(productID.Hex())
+		h.Col = col
+		err := h.GetAvailability(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("delete warehouse", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/warehouses/"+warehouseIDs[0], nil)
+		res := httptest.NewRecorder()
+		e := echo.New()
+		c := e.NewContext(req, res)
+		c.SetParamNames("id")
+		c.SetParamValues(warehouseIDs[0])
+		err := wh.DeleteWarehouse(c)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.Code)
+		warehouseID, _ := primitive.ObjectIDFromHex(warehouseIDs[0])
+		count, err := locCol.CountDocuments(context.Background(), map[string]interface{}{"warehouse_id": warehouseID})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), count)
+		stock, httpError := findStock(context.Background(), productID, stockCol)
+		assert.Nil(t, httpError)
+		assert.Equal(t, int64(7), stock.OnHand)
+	})
+}
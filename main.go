@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -18,6 +19,7 @@ import (
 	"github.com/labstack/gommon/log"
 	"github.com/labstack/gommon/random"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 )
 
 const (
@@ -26,11 +28,22 @@ const (
 )
 
 var (
-	c        *mongo.Client
-	db       *mongo.Database
-	prodCol  *mongo.Collection
-	usersCol *mongo.Collection
-	cfg      config.Properties
+	c          *mongo.Client
+	db         *mongo.Database
+	prodCol    *mongo.Collection
+	usersCol   *mongo.Collection
+	suggestCol *mongo.Collection
+	revCol     *mongo.Collection
+	ratesCol   *mongo.Collection
+	promoCol   *mongo.Collection
+	stockCol   *mongo.Collection
+	whCol      *mongo.Collection
+	locCol     *mongo.Collection
+	vendorCol  *mongo.Collection
+	catCol     *mongo.Collection
+	changeCol  *mongo.Collection
+	images     *gridfs.Bucket
+	cfg        config.Properties
 )
 
 func init() {
@@ -46,6 +59,20 @@ func init() {
 	db = c.Database(cfg.DBName)
 	prodCol = db.Collection(cfg.ProductCollection)
 	usersCol = db.Collection(cfg.UsersCollection)
+	suggestCol = db.Collection(cfg.SuggestCollection)
+	revCol = db.Collection(cfg.RevCollection)
+	ratesCol = db.Collection(cfg.RatesCollection)
+	promoCol = db.Collection(cfg.PromoCollection)
+	stockCol = db.Collection(cfg.StockCollection)
+	whCol = db.Collection(cfg.WarehouseCollection)
+	locCol = db.Collection(cfg.LocationCollection)
+	vendorCol = db.Collection(cfg.VendorCollection)
+	catCol = db.Collection(cfg.CategoryCollection)
+	changeCol = db.Collection(cfg.ChangeCollection)
+	images, err = gridfs.NewBucket(db, options.GridFSBucket().SetName(cfg.ImageBucket))
+	if err != nil {
+		log.Fatalf("Unable to open the images bucket : %v", err)
+	}
 
 	isUserIndexUnique := true
 	indexModel := mongo.IndexModel{
@@ -58,6 +85,120 @@ func init() {
 	if err != nil {
 		log.Fatalf("Unable to create an index : %+v", err)
 	}
+	textIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "product_name", Value: "text"},
+			{Key: "vendor", Value: "text"},
+			{Key: "accessories", Value: "text"},
+		},
+	}
+	_, err = prodCol.Indexes().CreateOne(ctx, textIndexModel)
+	if err != nil {
+		log.Fatalf("Unable to create an index : %+v", err)
+	}
+	suggestIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "prefixes", Value: 1},
+			{Key: "count", Value: -1},
+			{Key: "updated_at", Value: -1},
+		},
+	}
+	_, err = suggestCol.Indexes().CreateOne(ctx, suggestIndexModel)
+	if err != nil {
+		log.Fatalf("Unable to create an index : %+v", err)
+	}
+	isRevisionUnique := true
+	revIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "product_id", Value: 1},
+			{Key: "rev", Value: -1},
+		},
+		Options: &options.IndexOptions{
+			Unique: &isRevisionUnique,
+		},
+	}
+	_, err = revCol.Indexes().CreateOne(ctx, revIndexModel)
+	if err != nil {
+		log.Fatalf("Unable to create an index : %+v", err)
+	}
+	isLocationUnique := true
+	locIndexModels := []mongo.IndexModel{
+		{Keys: bson.M{"location": "2dsphere"}},
+		{
+			Keys: bson.D{
+				{Key: "warehouse_id", Value: 1},
+				{Key: "product_id", Value: 1},
+			},
+			Options: &options.IndexOptions{
+				Unique: &isLocationUnique,
+			},
+		},
+	}
+	_, err = locCol.Indexes().CreateMany(ctx, locIndexModels)
+	if err != nil {
+		log.Fatalf("Unable to create an index : %+v", err)
+	}
+	isSlugUnique := true
+	vendorIndexModel := mongo.IndexModel{
+		Keys: bson.M{"slug": 1},
+		Options: &options.IndexOptions{
+			Unique: &isSlugUnique,
+		},
+	}
+	_, err = vendorCol.Indexes().CreateOne(ctx, vendorIndexModel)
+	if err != nil {
+		log.Fatalf("Unable to create an index : %+v", err)
+	}
+	_, err = prodCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"vendor": 1}},
+		{Keys: bson.M{"categories": 1}},
+		{Keys: bson.M{"accessory_ids": 1}},
+		{Keys: bson.M{"updated_at": -1}},
+		{
+			Keys: bson.M{"variants.sku": 1},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"variants.sku": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		log.Fatalf("Unable to create an index : %+v", err)
+	}
+	isCategorySlugUnique := true
+	catIndexModels := []mongo.IndexModel{
+		{
+			Keys: bson.M{"slug": 1},
+			Options: &options.IndexOptions{
+				Unique: &isCategorySlugUnique,
+			},
+		},
+		{Keys: bson.M{"ancestors": 1}},
+		{Keys: bson.M{"parent_id": 1}},
+	}
+	_, err = catCol.Indexes().CreateMany(ctx, catIndexModels)
+	if err != nil {
+		log.Fatalf("Unable to create an index : %+v", err)
+	}
+	if cfg.MigratePrices {
+		n, err := handlers.MigratePrices(ctx, prodCol)
+		if err != nil {
+			log.Fatalf("Unable to migrate the prices : %+v", err)
+		}
+		log.Infof("Migrated %d integer and double prices to Decimal128", n)
+	}
+	if cfg.MigrateVendors {
+		n, err := handlers.MigrateVendors(ctx, prodCol, vendorCol)
+		if err != nil {
+			log.Fatalf("Unable to migrate the vendors : %+v", err)
+		}
+		log.Infof("Migrated the vendor of %d products to a slug", n)
+	}
+	if cfg.RatesFile != "" {
+		n, err := handlers.LoadRates(ctx, cfg.RatesFile, cfg.BaseCurrency, ratesCol)
+		if err != nil {
+			log.Fatalf("Unable to load the exchange rates : %+v", err)
+		}
+		log.Infof("Loaded %d exchange rates from %s", n, cfg.RatesFile)
+	}
 }
 
 func addCorrelationID(next echo.HandlerFunc) echo.HandlerFunc {
@@ -95,6 +236,18 @@ func adminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+//purgeTrash hard deletes the products whose trash retention has passed, every interval
+func purgeTrash(h *handlers.ProductHandler, interval, retention time.Duration) {
+	for range time.Tick(interval) {
+		n, err := h.PurgeDeletedProducts(context.Background(), retention)
+		if err != nil {
+			log.Errorf("Unable to purge the deleted products : %v", err)
+			continue
+		}
+		log.Infof("Purged %d deleted products", n)
+	}
+}
+
 func main() {
 	e := echo.New()
 	e.Logger.SetLevel(log.DEBUG)
@@ -108,16 +261,75 @@ func main() {
 		Format: `${time_rfc3339_nano} ${remote_ip} ${header:X-Correlation-ID} ${host} ${method} ${uri} ${user_agent} ` +
 			`${status} ${error} ${latency_human}` + "\n",
 	}))
-	h := &handlers.ProductHandler{Col: prodCol}
+	h := &handlers.ProductHandler{Col: prodCol, SuggestCol: suggestCol, RevCol: revCol,
+		RateCol: ratesCol, PromoCol: promoCol, StockCol: stockCol, LocationCol: locCol,
+		VendorCol: vendorCol, CategoryCol: catCol, ChangeCol: changeCol, Images: images, MaxImageSize: cfg.MaxImageSize,
+		BaseCurrency: cfg.BaseCurrency}
 	uh := &handlers.UsersHandler{Col: usersCol}
+	rh := &handlers.RatesHandler{Col: ratesCol, Base: cfg.BaseCurrency}
+	ph := &handlers.PromotionsHandler{Col: promoCol, ChangeCol: changeCol}
+	wh := &handlers.WarehousesHandler{Col: whCol, StockCol: locCol, ProdCol: prodCol, InventoryCol: stockCol}
+	vh := &handlers.VendorsHandler{Col: vendorCol, ProdCol: prodCol}
+	ch := &handlers.CategoriesHandler{Col: catCol, ProdCol: prodCol}
+	e.GET("/products/search", h.SearchProducts)
+	e.GET("/products/facets", h.FacetProducts)
+	e.GET("/products/suggest", h.SuggestProducts)
+	e.POST("/products/suggest/rebuild", h.RebuildSuggestions, jwtMiddleware, adminMiddleware)
+	e.GET("/products/export", h.ExportProducts)
+	e.GET("/products/sku/:sku", h.GetProductBySKU)
+	e.POST("/products/import", h.ImportProducts, middleware.BodyLimit("10M"), jwtMiddleware, adminMiddleware)
+	e.GET("/products/trash", h.GetTrash, jwtMiddleware, adminMiddleware)
+	e.POST("/products/:id/restore", h.RestoreProduct, jwtMiddleware, adminMiddleware)
+	e.GET("/products/:id/history", h.GetProductHistory, jwtMiddleware, adminMiddleware)
+	e.POST("/products/:id/revert/:rev", h.RevertProduct, jwtMiddleware, adminMiddleware)
+	e.POST("/products/:id/stock/adjust", h.AdjustStock, jwtMiddleware, adminMiddleware)
+	e.POST("/products/:id/stock/reserve", h.ReserveStock, jwtMiddleware)
+	e.POST("/products/:id/stock/release", h.ReleaseStock, jwtMiddleware)
+	e.GET("/products/:id/availability", h.GetAvailability)
+	e.GET("/products/:id/compatible", h.GetCompatibleProducts)
+	e.POST("/products/:id/images", h.AddImages, middleware.BodyLimit("50M"), jwtMiddleware)
+	e.GET("/products/:id/images/:imageId", h.GetImage)
+	e.GET("/products/:id/images/:imageId/thumbnail", h.GetImageThumbnail)
 	e.GET("/products/:id", h.GetProduct)
 	e.DELETE("/products/:id", h.DeleteProduct, jwtMiddleware, adminMiddleware)
 	e.PUT("/products/:id", h.UpdateProduct, middleware.BodyLimit("1M"), jwtMiddleware)
+	e.PATCH("/products/:id", h.PatchProduct, middleware.BodyLimit("1M"), jwtMiddleware)
 	e.POST("/products", h.CreateProducts, middleware.BodyLimit("1M"), jwtMiddleware)
 	e.GET("/products", h.GetProducts)
 
+	e.GET("/rates", rh.GetRates)
+	e.PUT("/rates/:currency", rh.PutRate, jwtMiddleware, adminMiddleware)
+	e.DELETE("/rates/:currency", rh.DeleteRate, jwtMiddleware, adminMiddleware)
+
+	e.GET("/promotions", ph.GetPromotions, jwtMiddleware, adminMiddleware)
+	e.GET("/promotions/:id", ph.GetPromotion, jwtMiddleware, adminMiddleware)
+	e.POST("/promotions", ph.CreatePromotion, middleware.BodyLimit("1M"), jwtMiddleware, adminMiddleware)
+	e.PUT("/promotions/:id", ph.UpdatePromotion, middleware.BodyLimit("1M"), jwtMiddleware, adminMiddleware)
+	e.DELETE("/promotions/:id", ph.DeletePromotion, jwtMiddleware, adminMiddleware)
+
+	e.GET("/warehouses", wh.GetWarehouses)
+	e.POST("/warehouses", wh.CreateWarehouse, middleware.BodyLimit("1M"), jwtMiddleware, adminMiddleware)
+	e.PUT("/warehouses/:id", wh.UpdateWarehouse, middleware.BodyLimit("1M"), jwtMiddleware, adminMiddleware)
+	e.DELETE("/warehouses/:id", wh.DeleteWarehouse, jwtMiddleware, adminMiddleware)
+	e.PUT("/warehouses/:id/stock/:product_id", wh.SetLocationStock, jwtMiddleware, adminMiddleware)
+
+	e.GET("/vendors", vh.GetVendors)
+	e.GET("/vendors/:id/products", h.GetVendorProducts)
+	e.GET("/vendors/:id", vh.GetVendor)
+	e.POST("/vendors", vh.CreateVendor, middleware.BodyLimit("1M"), jwtMiddleware, adminMiddleware)
+	e.PUT("/vendors/:id", vh.UpdateVendor, middleware.BodyLimit("1M"), jwtMiddleware, adminMiddleware)
+	e.DELETE("/vendors/:id", vh.DeleteVendor, jwtMiddleware, adminMiddleware)
+
+	e.GET("/categories", ch.GetCategories)
+	e.GET("/categories/:id/products", h.GetCategoryProducts)
+	e.GET("/categories/:id", ch.GetCategory)
+	e.POST("/categories", ch.CreateCategory, middleware.BodyLimit("1M"), jwtMiddleware, adminMiddleware)
+	e.PUT("/categories/:id", ch.UpdateCategory, middleware.BodyLimit("1M"), jwtMiddleware, adminMiddleware)
+	e.DELETE("/categories/:id", ch.DeleteCategory, jwtMiddleware, adminMiddleware)
+
 	e.POST("/users", uh.CreateUser)
 	e.POST("/auth", uh.AuthnUser)
+	go purgeTrash(h, cfg.PurgeInterval, cfg.TrashRetention)
 	e.Logger.Infof("Listening on %s:%s", cfg.Host, cfg.Port)
 	e.Logger.Fatal(e.Start(fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)))
 }